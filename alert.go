@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Reason used for alerts about successful runs below the download threshold.
+const AlertReasonLowDownload = "low_download"
+
+// Alert is the JSON payload posted to the alert webhook.
+type Alert struct {
+	Reason              string            `json:"reason"` // A failure reason, low_download, crossed_below, or crossed_above
+	Message             string            `json:"message"`
+	RunID               string            `json:"runId"`
+	Time                time.Time         `json:"time"`
+	ConsecutiveFailures int               `json:"consecutiveFailures"`
+	LastSuccess         *time.Time        `json:"lastSuccess,omitempty"`
+	Suppressed          int               `json:"suppressed,omitempty"` // Alerts with the same reason throttled since the last one sent
+	Results             *Stats            `json:"results,omitempty"`
+	Direction           string            `json:"direction,omitempty"` // download or upload, when crossing the target
+	Target              float64           `json:"target,omitempty"`    // The target in Mbps, and the rates before and after crossing it
+	Previous            float64           `json:"previous,omitempty"`
+	Current             float64           `json:"current,omitempty"`
+	Annotations         map[string]string `json:"annotations,omitempty"`
+}
+
+// AlertWebhook posts alerts about failed runs, or runs below a download threshold,
+// to a URL (e.g. a Slack or PagerDuty integration). It is separate from the sinks,
+// which receive the results of every successful run.
+type AlertWebhook struct {
+	URL             string
+	MinDownloadMbps float64       // When positive, successful runs below this download rate trigger an alert
+	Throttle        time.Duration // Minimum time between alerts with the same reason
+	Client          *http.Client
+
+	mu         sync.Mutex
+	sent       map[string]time.Time // Time of the last alert sent per reason
+	suppressed map[string]int       // Alerts throttled per reason since the last one sent
+}
+
+// NewAlertWebhook creates an alert webhook posting to the given http or https URL.
+func NewAlertWebhook(rawURL string, minDownloadMbps float64, throttle time.Duration) (*AlertWebhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL")
+	}
+	return &AlertWebhook{
+		URL:             u.String(),
+		MinDownloadMbps: minDownloadMbps,
+		Throttle:        throttle,
+		Client:          newHTTPClient(10 * time.Second),
+	}, nil
+}
+
+// Breached returns whether the results of a successful run are below the threshold.
+func (w *AlertWebhook) Breached(stats *Stats) bool {
+	return w.MinDownloadMbps > 0 && stats.Download != nil && stats.Download.GetBandWithInMbps() < w.MinDownloadMbps
+}
+
+// Notify sends the alert unless another one with the same reason was sent within
+// the throttle period, in which case it is counted and reported with the next one.
+func (w *AlertWebhook) Notify(logger *log.Logger, alert Alert) {
+	if !w.allow(alert.Reason, alert.Time, &alert.Suppressed) {
+		logger.Printf("alert throttled (reason=%s)", alert.Reason)
+		return
+	}
+	w.Send(logger, alert)
+}
+
+// Send sends the alert without throttling, for one-time events like the transitions across a target.
+func (w *AlertWebhook) Send(logger *log.Logger, alert Alert) {
+	if err := w.post(alert); err != nil {
+		logger.Printf("cannot send alert (reason=%s): %v", alert.Reason, err)
+		return
+	}
+	logger.Printf("alert sent (reason=%s)", alert.Reason)
+}
+
+// allow records an alert with the given reason, returning false when it must be
+// throttled. Otherwise, suppressed is set to the number of alerts throttled since the last one.
+func (w *AlertWebhook) allow(reason string, now time.Time, suppressed *int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sent == nil {
+		w.sent = make(map[string]time.Time)
+		w.suppressed = make(map[string]int)
+	}
+	if last, ok := w.sent[reason]; ok && now.Sub(last) < w.Throttle {
+		w.suppressed[reason]++
+		return false
+	}
+	w.sent[reason] = now
+	*suppressed = w.suppressed[reason]
+	w.suppressed[reason] = 0
+	return true
+}
+
+func (w *AlertWebhook) post(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
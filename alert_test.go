@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewAlertWebhook(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: "https://hooks.example.com/alerts"},
+		{raw: "http://127.0.0.1:8080/alerts"},
+		{raw: "ftp://hooks.example.com/alerts", wantErr: true},
+		{raw: "hooks.example.com/alerts", wantErr: true},
+		{raw: "http://hooks example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if _, err := NewAlertWebhook(tt.raw, 0, time.Hour); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAlertWebhookBreached(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		stats     *Stats
+		want      bool
+	}{
+		{"disabled", 0, &Stats{Download: &BandwidthStats{Bandwidth: 125000}}, false},
+		{"below", 50, &Stats{Download: &BandwidthStats{Bandwidth: 125000}}, true},
+		{"above", 50, &Stats{Download: &BandwidthStats{Bandwidth: 12500000}}, false},
+		{"no download", 50, &Stats{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &AlertWebhook{MinDownloadMbps: tt.threshold}
+			if got := w.Breached(tt.stats); got != tt.want {
+				t.Errorf("Breached() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// alertReceiver accepts the alerts posted to it, responding with the given status.
+type alertReceiver struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func newAlertReceiver(t *testing.T, status int) (*alertReceiver, string) {
+	t.Helper()
+	receiver := &alertReceiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		receiver.mu.Lock()
+		receiver.alerts = append(receiver.alerts, alert)
+		receiver.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return receiver, server.URL
+}
+
+func (r *alertReceiver) Alerts() []Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Alert(nil), r.alerts...)
+}
+
+func TestAlertWebhookThrottle(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	type sent struct {
+		reason     string
+		suppressed int
+	}
+	tests := []struct {
+		name   string
+		alerts []Alert
+		want   []sent
+	}{
+		{
+			name:   "first alert",
+			alerts: []Alert{{Reason: "timeout", Time: start}},
+			want:   []sent{{"timeout", 0}},
+		},
+		{
+			name: "flapping",
+			alerts: []Alert{
+				{Reason: "timeout", Time: start},
+				{Reason: "timeout", Time: start.Add(10 * time.Minute)},
+				{Reason: "timeout", Time: start.Add(50 * time.Minute)},
+				{Reason: "timeout", Time: start.Add(time.Hour)},
+			},
+			want: []sent{{"timeout", 0}, {"timeout", 2}},
+		},
+		{
+			name: "throttled per reason",
+			alerts: []Alert{
+				{Reason: "timeout", Time: start},
+				{Reason: "exec", Time: start.Add(time.Minute)},
+				{Reason: "timeout", Time: start.Add(2 * time.Minute)},
+				{Reason: AlertReasonLowDownload, Time: start.Add(3 * time.Minute)},
+			},
+			want: []sent{{"timeout", 0}, {"exec", 0}, {AlertReasonLowDownload, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, url := newAlertReceiver(t, http.StatusOK)
+			w, err := NewAlertWebhook(url, 0, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, alert := range tt.alerts {
+				w.Notify(testLogger(t), alert)
+			}
+			got := receiver.Alerts()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d alerts, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, alert := range got {
+				if alert.Reason != tt.want[i].reason || alert.Suppressed != tt.want[i].suppressed {
+					t.Errorf("alert %d has reason %q and %d suppressed, want %q and %d", i, alert.Reason, alert.Suppressed, tt.want[i].reason, tt.want[i].suppressed)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertWebhookSendError(t *testing.T) {
+	receiver, url := newAlertReceiver(t, http.StatusInternalServerError)
+	w, err := NewAlertWebhook(url, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.post(Alert{Reason: "timeout"}); err == nil {
+		t.Error("got no error for a failed delivery")
+	}
+	// Not delivering the alert must not crash the run.
+	w.Send(testLogger(t), Alert{Reason: "timeout"})
+	if got := len(receiver.Alerts()); got != 2 {
+		t.Errorf("got %d delivery attempts, want 2", got)
+	}
+}
+
+func TestRunAlerts(t *testing.T) {
+	failing := `echo '[error] Configuration - Could not retrieve or read configuration (ConfigurationError)' >&2; exit 1`
+	tests := []struct {
+		name      string
+		script    string // The fake CLI, or the fixture when empty
+		threshold float64
+		want      string // Reason of the expected alert, if any
+	}{
+		{name: "success", threshold: 50},
+		{name: "below the threshold", threshold: 200, want: AlertReasonLowDownload},
+		{name: "failure", script: failing, want: "exec"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver, url := newAlertReceiver(t, http.StatusOK)
+			alerts, err := NewAlertWebhook(url, tt.threshold, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cli := fixtureCLI(t, "result.json")
+			if tt.script != "" {
+				cli = fakeCLI(t, tt.script)
+			}
+			now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+			tester := &SpeedTester{Command: cli, Alerts: alerts, Clock: newFakeClock(now)}
+			tester.Metrics()
+			tester.Run()
+
+			got := receiver.Alerts()
+			if tt.want == "" {
+				if len(got) > 0 {
+					t.Errorf("got unexpected alerts %+v", got)
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d alerts, want 1", len(got))
+			}
+			if alert := got[0]; alert.Reason != tt.want || alert.Message == "" || alert.RunID == "" || !alert.Time.Equal(now) {
+				t.Errorf("got alert %+v, want reason %q at %s", alert, tt.want, now)
+			}
+		})
+	}
+}
+
+func TestRunAlertsThrottled(t *testing.T) {
+	receiver, url := newAlertReceiver(t, http.StatusOK)
+	alerts, err := NewAlertWebhook(url, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	tester := &SpeedTester{Command: fakeCLI(t, `exit 1`), Alerts: alerts, Clock: clock}
+	tester.Metrics()
+	for range 3 {
+		tester.Run()
+		clock.Advance(15 * time.Minute)
+	}
+	clock.Advance(15 * time.Minute)
+	tester.Run()
+
+	got := receiver.Alerts()
+	if len(got) != 2 {
+		t.Fatalf("got %d alerts, want 2", len(got))
+	}
+	if got[0].ConsecutiveFailures != 1 || got[1].ConsecutiveFailures != 4 || got[1].Suppressed != 2 {
+		t.Errorf("got alerts %+v, want 1 and 4 consecutive failures, and 2 suppressed", got)
+	}
+}
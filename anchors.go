@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAnchorPort    = "443"           // Used for the anchors given without a port
+	defaultAnchorTimeout = 2 * time.Second // To measure the latency to each anchor
+)
+
+// ParseAnchors validates the anchors as host or host:port, adding the default port when missing.
+func ParseAnchors(values []string) ([]string, error) {
+	var anchors []string
+	for _, v := range values {
+		host, port, err := net.SplitHostPort(v)
+		if err != nil {
+			host, port = v, defaultAnchorPort
+		}
+		if host == "" {
+			return nil, fmt.Errorf("invalid anchor %q, expected host or host:port", v)
+		}
+		anchors = append(anchors, net.JoinHostPort(host, port))
+	}
+	return anchors, nil
+}
+
+// measureAnchors measures the latency to each anchor concurrently, for a view of the
+// general latency besides the one to the test server. The series of the anchors that
+// cannot be reached are removed until they respond again.
+func (t *SpeedTester) measureAnchors(logger *log.Logger, iface string) {
+	var wg sync.WaitGroup
+	for _, anchor := range t.Anchors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), t.AnchorTimeout)
+			defer cancel()
+			ping, err := tcpPing(ctx, anchor, iface, tcpPings)
+			if err != nil {
+				logger.Printf("cannot measure the latency to anchor %s: %v", anchor, err)
+				t.promStats.AnchorLatency.DeleteLabelValues(anchor)
+				return
+			}
+			t.promStats.AnchorLatency.WithLabelValues(anchor).Set(ping.Latency)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseAnchors(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    []string
+		wantErr bool
+	}{
+		{values: nil},
+		{values: []string{"1.1.1.1", "8.8.8.8:53"}, want: []string{"1.1.1.1:443", "8.8.8.8:53"}},
+		{values: []string{"one.one.one.one"}, want: []string{"one.one.one.one:443"}},
+		{values: []string{"::1"}, want: []string{"[::1]:443"}},
+		{values: []string{"[2606:4700::1111]:53"}, want: []string{"[2606:4700::1111]:53"}},
+		{values: []string{":53"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.values, ","), func(t *testing.T) {
+			got, err := ParseAnchors(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAnchors(t *testing.T) {
+	up, other := pingListener(t), pingListener(t)
+	const down = "127.0.0.1:1"
+	tester := &SpeedTester{
+		Command:       fixtureCLI(t, "result.json"),
+		Anchors:       []string{up, other, down},
+		AnchorTimeout: time.Second,
+	}
+	metrics := tester.Metrics()
+	// The series of an anchor that stopped responding is removed.
+	metrics.AnchorLatency.WithLabelValues(down).Set(1)
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for _, anchor := range []string{up, other} {
+		if got := testutil.ToFloat64(metrics.AnchorLatency.WithLabelValues(anchor)); got <= 0 {
+			t.Errorf("speedtest_anchor_latency_ms{target=%q} = %v, want a latency", anchor, got)
+		}
+	}
+	if got := testutil.CollectAndCount(metrics.AnchorLatency); got != 2 {
+		t.Errorf("got %d series of speedtest_anchor_latency_ms, want 2", got)
+	}
+}
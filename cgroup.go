@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errCgroupsUnsupported = errors.New("cgroups are only supported on Linux")
+
+// CgroupLimits bounds the resources of the speedtest CLI, so a test doesn't starve
+// the other workloads of a small or shared host.
+type CgroupLimits struct {
+	CPUs        float64 // Maximum number of CPUs, e.g. 0.5 for half of one (0 for no limit)
+	MemoryBytes int64   // Maximum memory, above which the CLI is killed (0 for no limit)
+}
+
+func (l CgroupLimits) Enabled() bool {
+	return l.CPUs > 0 || l.MemoryBytes > 0
+}
+
+// ParseMemorySize parses a size in bytes with an optional K, M or G suffix, as powers
+// of 1024, e.g. 256M. An empty value means no limit.
+func ParseMemorySize(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	number := strings.ToUpper(value)
+	for i, suffix := range []string{"K", "M", "G"} {
+		if n, ok := strings.CutSuffix(number, suffix); ok {
+			number, multiplier = n, 1<<(10*(i+1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a positive number of bytes with an optional K, M or G suffix", value)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,136 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const cgroupCPUPeriod = 100000 // Period of the CPU quota in µs
+
+// Variables so the tests can use a fake hierarchy.
+var (
+	cgroupRoot = "/sys/fs/cgroup"    // Mount point of the cgroup v2 unified hierarchy
+	procCgroup = "/proc/self/cgroup" // Cgroups of this process
+)
+
+// Cgroup is the cgroup v2 where each execution of the CLI is placed when it starts.
+type Cgroup struct {
+	Path string
+	dir  *os.File // Passed to the kernel to place the CLI in the cgroup
+}
+
+// NewCgroup creates a child of the cgroup of this process with the given limits. The
+// cpu and memory controllers must be delegated to it, e.g. with Delegate=yes on systemd.
+// As cgroup v2 doesn't allow processes in a cgroup whose children have controllers,
+// this process moves to a sibling leaf when it is in the way.
+func NewCgroup(limits CgroupLimits) (*Cgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroup v2 is not mounted at %s, the legacy and hybrid hierarchies are not supported", cgroupRoot)
+	}
+	parent, err := ownCgroup()
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	if limits.CPUs > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if limits.MemoryBytes > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if err := enableControllers(parent, controllers); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(parent, "speedtest-cli")
+	if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	if limits.CPUs > 0 {
+		quota := fmt.Sprintf("%d %d", int64(limits.CPUs*cgroupCPUPeriod), cgroupCPUPeriod)
+		if err := writeCgroupFile(path, "cpu.max", quota); err != nil {
+			return nil, err
+		}
+	}
+	if limits.MemoryBytes > 0 {
+		if err := writeCgroupFile(path, "memory.max", strconv.FormatInt(limits.MemoryBytes, 10)); err != nil {
+			return nil, err
+		}
+		// Otherwise the CLI would be swapped out instead of being killed, when there is swap.
+		writeCgroupFile(path, "memory.swap.max", "0")
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Cgroup{Path: path, dir: dir}, nil
+}
+
+// Apply makes the command start in the cgroup, so its children are bound too.
+func (c *Cgroup) Apply(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(c.dir.Fd())}
+}
+
+// ownCgroup returns the path of the cgroup of this process on the unified hierarchy.
+func ownCgroup() (string, error) {
+	f, err := os.Open(procCgroup)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return filepath.Join(cgroupRoot, path), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cannot find the cgroup v2 of this process")
+}
+
+// enableControllers makes the controllers available to the children of the cgroup.
+func enableControllers(parent string, controllers []string) error {
+	data, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	available := strings.Fields(string(data))
+	var enable []string
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			return fmt.Errorf("the %s controller is not delegated to %s", c, parent)
+		}
+		enable = append(enable, "+"+c)
+	}
+	err = writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(enable, " "))
+	if !errors.Is(err, syscall.EBUSY) {
+		return err
+	}
+	leaf := filepath.Join(parent, "speedtester")
+	if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if err := writeCgroupFile(leaf, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+		return fmt.Errorf("cannot move out of %s to enable its controllers: %w", parent, err)
+	}
+	if err := writeCgroupFile(parent, "cgroup.subtree_control", strings.Join(enable, " ")); err != nil {
+		return fmt.Errorf("cannot enable the controllers of %s, are there other processes in it? %w", parent, err)
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0)
+}
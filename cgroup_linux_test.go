@@ -0,0 +1,117 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// fakeCgroups replaces the cgroup v2 hierarchy with a fake one, where this process is
+// in the given cgroup, whose parent delegates the given controllers. Like the kernel,
+// it populates the interface files of the cgroup of the CLI, returning its path.
+func fakeCgroups(t *testing.T, own, controllers string, mounted bool) string {
+	t.Helper()
+	root := t.TempDir()
+	oldRoot, oldProc := cgroupRoot, procCgroup
+	cgroupRoot, procCgroup = root, filepath.Join(t.TempDir(), "cgroup")
+	t.Cleanup(func() { cgroupRoot, procCgroup = oldRoot, oldProc })
+
+	// Hybrid hierarchies list the v1 controllers too.
+	if err := os.WriteFile(procCgroup, []byte("4:memory:/\n1:cpu:/\n"+own+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parent := filepath.Join(root, "system.slice", "speedtester.service")
+	files := map[string]string{
+		filepath.Join(parent, "cgroup.controllers"):               controllers,
+		filepath.Join(parent, "cgroup.subtree_control"):           "",
+		filepath.Join(parent, "speedtest-cli", "cpu.max"):         "max 100000",
+		filepath.Join(parent, "speedtest-cli", "memory.max"):      "max",
+		filepath.Join(parent, "speedtest-cli", "memory.swap.max"): "max",
+	}
+	if mounted {
+		files[filepath.Join(root, "cgroup.controllers")] = "cpu memory io pids"
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(parent, "speedtest-cli")
+}
+
+func TestNewCgroup(t *testing.T) {
+	const own = "0::/system.slice/speedtester.service"
+	tests := []struct {
+		name        string
+		own         string // Entry of the unified hierarchy in /proc/self/cgroup
+		controllers string // Delegated to the cgroup of this process
+		unmounted   bool
+		limits      CgroupLimits
+		want        map[string]string // Expected content of the interface files
+		wantErr     bool
+	}{
+		{
+			name:        "cpu and memory",
+			own:         own,
+			controllers: "cpu memory",
+			limits:      CgroupLimits{CPUs: 0.5, MemoryBytes: 256 << 20},
+			want: map[string]string{
+				"../cgroup.subtree_control": "+cpu +memory",
+				"cpu.max":                   "50000 100000",
+				"memory.max":                "268435456",
+				"memory.swap.max":           "0",
+			},
+		},
+		{
+			name:        "cpu only",
+			own:         own,
+			controllers: "cpu memory",
+			limits:      CgroupLimits{CPUs: 1.5},
+			want: map[string]string{
+				"../cgroup.subtree_control": "+cpu",
+				"cpu.max":                   "150000 100000",
+				"memory.max":                "max",
+			},
+		},
+		{name: "controller not delegated", own: own, controllers: "cpu", limits: CgroupLimits{MemoryBytes: 256 << 20}, wantErr: true},
+		{name: "legacy hierarchy", own: own, controllers: "cpu memory", unmounted: true, limits: CgroupLimits{CPUs: 1}, wantErr: true},
+		{name: "process outside the unified hierarchy", controllers: "cpu memory", limits: CgroupLimits{CPUs: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := fakeCgroups(t, tt.own, tt.controllers, !tt.unmounted)
+			cgroup, err := NewCgroup(tt.limits)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { cgroup.dir.Close() })
+			if cgroup.Path != path {
+				t.Errorf("got the cgroup %s, want %s", cgroup.Path, path)
+			}
+			for name, want := range tt.want {
+				data, err := os.ReadFile(filepath.Join(path, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(data); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+
+			cmd := exec.Command("true")
+			cgroup.Apply(cmd)
+			if attr := cmd.SysProcAttr; attr == nil || !attr.UseCgroupFD || attr.CgroupFD != int(cgroup.dir.Fd()) {
+				t.Errorf("got the process attributes %+v, want to start in the cgroup", attr)
+			}
+		})
+	}
+}
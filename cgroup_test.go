@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "1048576", want: 1 << 20},
+		{value: "512K", want: 512 << 10},
+		{value: "256M", want: 256 << 20},
+		{value: "2g", want: 2 << 30},
+		{value: "0", wantErr: true},
+		{value: "-1M", wantErr: true},
+		{value: "1.5G", wantErr: true},
+		{value: "256MB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMemorySize(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMemorySize(%q) got error %v, want an error: %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMemorySize(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// Cgroup is not available on this platform.
+type Cgroup struct{}
+
+// NewCgroup always fails with errCgroupsUnsupported on this platform.
+func NewCgroup(limits CgroupLimits) (*Cgroup, error) {
+	return nil, errCgroupsUnsupported
+}
+
+func (c *Cgroup) Apply(cmd *exec.Cmd) {}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClock is a Clock whose time only moves with Advance, firing the timers that
+// expire on the way, so the scheduler can be driven deterministically.
+type fakeClock struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // Signaled when a timer is armed
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	timer := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	timer.Reset(d)
+	return timer
+}
+
+// Advance moves the time forward by d, firing the expired timers in order.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	active := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.when.After(c.now) {
+			active = append(active, timer)
+			continue
+		}
+		select {
+		case timer.ch <- timer.when:
+		default:
+		}
+	}
+	c.timers = active
+}
+
+// AdvanceTo moves the time forward to the given time.
+func (c *fakeClock) AdvanceTo(t time.Time) {
+	c.Advance(t.Sub(c.Now()))
+}
+
+// WaitForTimers waits until at least n timers are armed, e.g. by a goroutine under test
+// that must be waiting before the time is advanced.
+func (c *fakeClock) WaitForTimers(t *testing.T, n int) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for len(c.timers) < n {
+			c.cond.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %d timers", n)
+	}
+}
+
+// Armed returns the number of armed timers.
+func (c *fakeClock) Armed() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	ch    chan time.Time
+	when  time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return t.remove()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	active := t.remove()
+	t.when = c.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.ch <- t.when:
+		default:
+		}
+		return active
+	}
+	c.timers = append(c.timers, t)
+	c.cond.Broadcast()
+	return active
+}
+
+// remove discards the timer from the armed ones, returning whether it was armed.
+func (t *fakeTimer) remove() bool {
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	early, late := clock.After(time.Minute), clock.After(time.Hour)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Stop() = false for an armed timer")
+	}
+	clock.Advance(30 * time.Minute)
+	select {
+	case got := <-early:
+		if want := start.Add(time.Minute); !got.Equal(want) {
+			t.Errorf("fired with %s, want %s", got, want)
+		}
+	default:
+		t.Error("the expired timer did not fire")
+	}
+	select {
+	case <-late:
+		t.Error("the timer fired before expiring")
+	case <-stopped.C():
+		t.Error("the stopped timer fired")
+	default:
+	}
+	if got, want := clock.Now(), start.Add(30*time.Minute); !got.Equal(want) {
+		t.Errorf("Now() = %s, want %s", got, want)
+	}
+}
+
+// newLoopTester returns a tester generating synthetic results that never fail, so the
+// runs of the loop are instantaneous and deterministic.
+func newLoopTester(clock Clock) *SpeedTester {
+	simulator := NewSyntheticRunner()
+	simulator.FailureRate = 0
+	tester := &SpeedTester{Frequency: 15 * time.Minute, Clock: clock, Simulator: simulator, OnFailure: OnFailureRetain, EWMAAlpha: defaultEWMAAlpha}
+	tester.Metrics() // Like main, before any goroutine uses them
+	return tester
+}
+
+// startLoop runs the loop of the tester until the end of the test.
+func startLoop(t *testing.T, tester *SpeedTester, clock *fakeClock) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tester.runLoop(ctx, clock)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestRunLoop(t *testing.T) {
+	tests := []struct {
+		name  string
+		align bool
+		start time.Time
+		runs  []time.Time // Expected times of the scheduled runs after the first one
+	}{
+		{
+			name:  "every frequency since the start",
+			start: time.Date(2024, 6, 1, 10, 7, 0, 0, time.UTC),
+			runs:  []time.Time{time.Date(2024, 6, 1, 10, 22, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 37, 0, 0, time.UTC)},
+		},
+		{
+			name:  "aligned to the wall clock",
+			align: true,
+			start: time.Date(2024, 6, 1, 10, 7, 0, 0, time.UTC),
+			runs:  []time.Time{time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(tt.start)
+			tester := newLoopTester(clock)
+			tester.Align = tt.align
+			startLoop(t, tester, clock)
+			for i, at := range tt.runs {
+				clock.WaitForTimers(t, 1)
+				if got := tester.Status().NextRun; got == nil || !got.Equal(at) {
+					t.Fatalf("run %d scheduled at %v, want %s", i+2, got, at)
+				}
+				// Just before the scheduled time, nothing happens.
+				clock.AdvanceTo(at.Add(-time.Second))
+				if got := testutil.ToFloat64(tester.Metrics().Requests.WithLabelValues("ok")); got != float64(i+1) {
+					t.Fatalf("got %v runs before %s, want %d", got, at, i+1)
+				}
+				clock.AdvanceTo(at)
+				waitForRuns(t, tester, i+2)
+			}
+		})
+	}
+}
+
+func TestRunLoopMaintenance(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 10, 7, 0, 0, time.UTC))
+	tester := newLoopTester(clock)
+	tester.Align = true
+	window, err := ParseMaintenanceWindow("2024-06-01T10:10:00Z/2024-06-01T10:20:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester.Maintenance = []MaintenanceWindow{window}
+	startLoop(t, tester, clock)
+	waitForRuns(t, tester, 1)
+
+	// The run at 10:15 is skipped, and the loop waits for the next boundary.
+	clock.WaitForTimers(t, 1)
+	clock.AdvanceTo(time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC))
+	clock.WaitForTimers(t, 1)
+	if got := testutil.ToFloat64(tester.Metrics().Paused); got != 1 {
+		t.Errorf("speedtest_paused = %v during the window, want 1", got)
+	}
+	if got := testutil.ToFloat64(tester.Metrics().Requests.WithLabelValues("ok")); got != 1 {
+		t.Errorf("got %v runs during the window, want 1", got)
+	}
+
+	clock.AdvanceTo(time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC))
+	waitForRuns(t, tester, 2)
+	if got := testutil.ToFloat64(tester.Metrics().Paused); got != 0 {
+		t.Errorf("speedtest_paused = %v after the window, want 0", got)
+	}
+}
+
+// waitForRuns waits until the tester completed the given number of successful runs.
+func waitForRuns(t *testing.T, tester *SpeedTester, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(tester.Metrics().Requests.WithLabelValues("ok")) < float64(n) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d runs", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNextRunGauge(t *testing.T) {
+	tests := []struct {
+		name  string
+		align bool
+		runs  []time.Time // Expected times of the scheduled runs after the first one
+	}{
+		{
+			name: "every frequency since the start",
+			runs: []time.Time{time.Date(2024, 6, 1, 10, 22, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 37, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 52, 0, 0, time.UTC)},
+		},
+		{
+			name:  "aligned to the wall clock",
+			align: true,
+			runs:  []time.Time{time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 30, 0, 0, time.UTC), time.Date(2024, 6, 1, 10, 45, 0, 0, time.UTC)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Date(2024, 6, 1, 10, 7, 0, 0, time.UTC))
+			tester := newLoopTester(clock)
+			tester.Align = tt.align
+			startLoop(t, tester, clock)
+			for i, at := range tt.runs {
+				clock.WaitForTimers(t, 1)
+				if got, want := testutil.ToFloat64(tester.Metrics().NextRun), float64(at.Unix()); got != want {
+					t.Fatalf("after run %d, speedtest_next_run_timestamp_seconds = %v, want %v (%s)", i+1, got, want, at)
+				}
+				clock.AdvanceTo(at)
+				waitForRuns(t, tester, i+2)
+			}
+		})
+	}
+}
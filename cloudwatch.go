@@ -0,0 +1,78 @@
+//go:build cloudwatch
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// cloudWatchAPI is the subset of the CloudWatch client used by the sink.
+type cloudWatchAPI interface {
+	PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error)
+}
+
+// CloudWatchSink pushes the results of each run to AWS CloudWatch, using the server ID as a dimension.
+type CloudWatchSink struct {
+	Namespace string
+	Timeout   time.Duration
+	client    cloudWatchAPI
+}
+
+// NewCloudWatchSink creates a sink using the region and credentials from the standard AWS environment.
+func NewCloudWatchSink(namespace string) (Sink, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &CloudWatchSink{
+		Namespace: namespace,
+		Timeout:   10 * time.Second,
+		client:    cloudwatch.NewFromConfig(cfg),
+	}, nil
+}
+
+func (s *CloudWatchSink) Name() string {
+	return "cloudwatch"
+}
+
+func (s *CloudWatchSink) Publish(stats *Stats) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	_, err := s.client.PutMetricData(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace:  aws.String(s.Namespace),
+		MetricData: cloudWatchMetrics(stats, time.Now()),
+	})
+	return err
+}
+
+// cloudWatchMetrics converts the results into CloudWatch data points, deriving the units from the metric names.
+func cloudWatchMetrics(stats *Stats, timestamp time.Time) []types.MetricDatum {
+	dimensions := []types.Dimension{{Name: aws.String("server_id"), Value: aws.String(stats.Server.GetID())}}
+	var data []types.MetricDatum
+	for name, value := range stats.ToMap() {
+		unit := types.StandardUnitNone
+		switch {
+		case strings.HasSuffix(name, "_mbps"):
+			unit = types.StandardUnitMegabitsSecond
+		case strings.HasSuffix(name, "_ms"):
+			unit = types.StandardUnitMilliseconds
+		case strings.HasSuffix(name, "_percent"):
+			unit = types.StandardUnitPercent
+		}
+		data = append(data, types.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Timestamp:  aws.Time(timestamp),
+			Unit:       unit,
+			Value:      aws.Float64(value),
+		})
+	}
+	return data
+}
@@ -0,0 +1,10 @@
+//go:build !cloudwatch
+
+package main
+
+import "fmt"
+
+// NewCloudWatchSink is only available when building with the cloudwatch tag.
+func NewCloudWatchSink(namespace string) (Sink, error) {
+	return nil, fmt.Errorf("built without CloudWatch support (build with -tags cloudwatch)")
+}
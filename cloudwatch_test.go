@@ -0,0 +1,87 @@
+//go:build cloudwatch
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// fakeCloudWatch records the data pushed by the sink.
+type fakeCloudWatch struct {
+	inputs []*cloudwatch.PutMetricDataInput
+	err    error
+}
+
+func (c *fakeCloudWatch) PutMetricData(ctx context.Context, params *cloudwatch.PutMetricDataInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.PutMetricDataOutput, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("the request has no deadline")
+	}
+	c.inputs = append(c.inputs, params)
+	return &cloudwatch.PutMetricDataOutput{}, c.err
+}
+
+func TestCloudWatchSink(t *testing.T) {
+	client := new(fakeCloudWatch)
+	sink := &CloudWatchSink{Namespace: "SpeedTester", Timeout: time.Second, client: client}
+	stats := loadResult(t, "result.json")
+	stats.PacketLoss = 1.5
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.inputs) != 1 {
+		t.Fatalf("got %d requests, want 1", len(client.inputs))
+	}
+	input := client.inputs[0]
+	if got := aws.ToString(input.Namespace); got != "SpeedTester" {
+		t.Errorf("got namespace %q", got)
+	}
+	want := map[string]struct {
+		value float64
+		unit  types.StandardUnit
+	}{
+		"download_mbps":       {100, types.StandardUnitMegabitsSecond},
+		"upload_mbps":         {20, types.StandardUnitMegabitsSecond},
+		"download_latency_ms": {20.1, types.StandardUnitMilliseconds},
+		"download_jitter_ms":  {3.4, types.StandardUnitMilliseconds},
+		"upload_latency_ms":   {30.1, types.StandardUnitMilliseconds},
+		"upload_jitter_ms":    {4.4, types.StandardUnitMilliseconds},
+		"ping_latency_ms":     {10.5, types.StandardUnitMilliseconds},
+		"ping_jitter_ms":      {1.2, types.StandardUnitMilliseconds},
+		"packet_loss_percent": {1.5, types.StandardUnitPercent},
+	}
+	if len(input.MetricData) != len(want) {
+		t.Errorf("got %d data points, want %d", len(input.MetricData), len(want))
+	}
+	for _, datum := range input.MetricData {
+		name := aws.ToString(datum.MetricName)
+		w, ok := want[name]
+		if !ok {
+			t.Errorf("unexpected metric %s", name)
+			continue
+		}
+		if got := aws.ToFloat64(datum.Value); got != w.value || datum.Unit != w.unit {
+			t.Errorf("%s = %v %s, want %v %s", name, got, datum.Unit, w.value, w.unit)
+		}
+		if len(datum.Dimensions) != 1 || aws.ToString(datum.Dimensions[0].Name) != "server_id" || aws.ToString(datum.Dimensions[0].Value) != "14774" {
+			t.Errorf("%s has the dimensions %+v, want server_id=14774", name, datum.Dimensions)
+		}
+		if datum.Timestamp == nil {
+			t.Errorf("%s has no timestamp", name)
+		}
+	}
+}
+
+func TestCloudWatchSinkError(t *testing.T) {
+	throttled := errors.New("throttled")
+	sink := &CloudWatchSink{Namespace: "SpeedTester", Timeout: time.Second, client: &fakeCloudWatch{err: throttled}}
+	if err := sink.Publish(loadResult(t, "result.json")); !errors.Is(err, throttled) {
+		t.Errorf("got error %v, want %v", err, throttled)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// resultAgeCollector exposes how stale the published results are, computed at
+// scrape time, so dashboards don't need to subtract timestamps in PromQL.
+type resultAgeCollector struct {
+	desc        *prometheus.Desc
+	lastSuccess func() time.Time // Start of the last successful run, zero when there was none
+	now         func() time.Time
+}
+
+func newResultAgeCollector(lastSuccess func() time.Time, now func() time.Time) *resultAgeCollector {
+	return &resultAgeCollector{
+		desc: prometheus.NewDesc(
+			"speedtest_result_age_seconds",
+			"Seconds since the last successful run, computed at scrape time",
+			nil, nil,
+		),
+		lastSuccess: lastSuccess,
+		now:         now,
+	}
+}
+
+func (c *resultAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect skips the metric until there is a successful run, as there is no result to age.
+func (c *resultAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	last := c.lastSuccess()
+	if last.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, c.now().Sub(last).Seconds())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResultAgeCollector(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		want        string // Expected exposition, empty when the metric is skipped
+	}{
+		{name: "no successful run"},
+		{name: "just now", lastSuccess: now, want: "speedtest_result_age_seconds 0\n"},
+		{name: "stale", lastSuccess: now.Add(-90*time.Minute - 500*time.Millisecond), want: "speedtest_result_age_seconds 5400.5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResultAgeCollector(func() time.Time { return tt.lastSuccess }, func() time.Time { return now })
+			want := ""
+			if tt.want != "" {
+				want = "# HELP speedtest_result_age_seconds Seconds since the last successful run, computed at scrape time\n# TYPE speedtest_result_age_seconds gauge\n" + tt.want
+			}
+			if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResultAge(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	tester := newLoopTester(clock)
+	metrics := tester.Metrics()
+	if got := testutil.CollectAndCount(metrics.Registry, "speedtest_result_age_seconds"); got != 0 {
+		t.Errorf("got %d result age metrics before the first run, want 0", got)
+	}
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Minute)
+	want := `
+# HELP speedtest_result_age_seconds Seconds since the last successful run, computed at scrape time
+# TYPE speedtest_result_age_seconds gauge
+speedtest_result_age_seconds 1200
+`
+	if err := testutil.GatherAndCompare(metrics.Registry, strings.NewReader(want), "speedtest_result_age_seconds"); err != nil {
+		t.Error(err)
+	}
+}
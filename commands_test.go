@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		want     string // Name of the command, or empty when not found
+		wantArgs []string
+	}{
+		{name: "bare invocation", want: "serve"},
+		{name: "flags only", args: []string{"-port", "9112", "-frequency=1h"}, want: "serve", wantArgs: []string{"-port", "9112", "-frequency=1h"}},
+		{name: "help", args: []string{"-h"}, want: "serve", wantArgs: []string{"-h"}},
+		{name: "serve", args: []string{"serve", "-port", "9112"}, want: "serve", wantArgs: []string{"-port", "9112"}},
+		{name: "run", args: []string{"run", "-server", "1234"}, want: "run", wantArgs: []string{"-server", "1234"}},
+		{name: "list-servers", args: []string{"list-servers", "-json"}, want: "list-servers", wantArgs: []string{"-json"}},
+		{name: "check", args: []string{"check"}, want: "check"},
+		{name: "dashboard", args: []string{"dashboard"}, want: "dashboard"},
+		{name: "unknown", args: []string{"start", "-port", "9112"}, wantArgs: []string{"-port", "9112"}},
+		{name: "flags before the command", args: []string{"-port", "9112", "run"}, want: "serve", wantArgs: []string{"-port", "9112", "run"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := findCommand(tt.args)
+			got := ""
+			if cmd != nil {
+				got = cmd.Name
+			}
+			if got != tt.want {
+				t.Errorf("got command %q, want %q", got, tt.want)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("got args %q, want %q", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range commands {
+		if seen[c.Name] {
+			t.Errorf("command %s is defined twice", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Description == "" || c.Run == nil {
+			t.Errorf("command %s lacks a description or a function", c.Name)
+		}
+	}
+}
+
+// captureStdout returns what the function writes to the standard output.
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	err = f()
+	w.Close()
+	return <-output, err
+}
+
+func TestCommandDispatch(t *testing.T) {
+	cli := fixtureCLI(t, "result.json")
+	tests := []struct {
+		args  []string
+		check func(t *testing.T, output string)
+	}{
+		{
+			args: []string{"run", "-path", cli},
+			check: func(t *testing.T, output string) {
+				var stats Stats
+				if err := json.Unmarshal([]byte(output), &stats); err != nil || stats.ISP != "Acme" {
+					t.Errorf("got results %q: %v", output, err)
+				}
+			},
+		},
+		{
+			args: []string{"dashboard"},
+			check: func(t *testing.T, output string) {
+				if !json.Valid([]byte(output)) || !strings.Contains(output, "speedtest_") {
+					t.Error("got an invalid dashboard")
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args[0], func(t *testing.T) {
+			cmd, args := findCommand(tt.args)
+			if cmd == nil {
+				t.Fatalf("command %s not found", tt.args[0])
+			}
+			output, err := captureStdout(t, func() error { return cmd.Run(args) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, output)
+		})
+	}
+}
+
+func TestServerBind(t *testing.T) {
+	tests := []struct {
+		bind         string
+		want         string
+		wantLoopback bool // Whether it only accepts local connections
+	}{
+		{bind: "", want: ":0"},
+		{bind: "0.0.0.0", want: "0.0.0.0:0"},
+		{bind: "127.0.0.1", want: "127.0.0.1:0", wantLoopback: true},
+		{bind: "::1", want: "[::1]:0", wantLoopback: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			server := newLoopTester(realClock{}).newServer(tt.bind, 0)
+			if server.Addr != tt.want {
+				t.Fatalf("got address %s, want %s", server.Addr, tt.want)
+			}
+			ln, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				t.Skipf("cannot listen on %s: %v", server.Addr, err)
+			}
+			go server.Serve(ln)
+			t.Cleanup(func() { server.Close() })
+			addr := ln.Addr().(*net.TCPAddr)
+			if got := addr.IP.IsLoopback(); got != tt.wantLoopback {
+				t.Errorf("bound to %s, want a loopback address: %t", addr, tt.wantLoopback)
+			}
+			if !tt.wantLoopback && !addr.IP.IsUnspecified() {
+				t.Errorf("bound to %s, want all the interfaces", addr)
+			}
+			resp, err := http.Get("http://" + net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port)) + "/status")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("got status %d from /status, want 200", resp.StatusCode)
+			}
+		})
+	}
+}
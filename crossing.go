@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Reasons of the alerts sent when a rate crosses its target.
+const (
+	AlertReasonCrossedBelow = "crossed_below"
+	AlertReasonCrossedAbove = "crossed_above"
+)
+
+// crossing tracks whether a rate is below or above its target, to report only the
+// transitions (regressions and recoveries) rather than every run on one side.
+type crossing struct {
+	Target   float64 // In Mbps; rates equal to the target are above it
+	known    bool
+	below    bool
+	previous float64
+}
+
+// update records the rate of a run, returning whether it crossed the target since the
+// previous one, and the previous rate. The first rate only sets the initial side.
+func (c *crossing) update(mbps float64) (crossed bool, previous float64) {
+	below := mbps < c.Target
+	crossed = c.known && below != c.below
+	previous = c.previous
+	c.known, c.below, c.previous = true, below, mbps
+	return crossed, previous
+}
+
+// checkCrossings logs an event, and sends an alert when the webhook is configured,
+// for each rate of the results that crossed its target since the previous run.
+func (t *SpeedTester) checkCrossings(logger *log.Logger, stats *Stats) {
+	for _, c := range []struct {
+		direction string
+		crossing  *crossing
+		results   *BandwidthStats
+	}{
+		{"download", t.DownloadTarget, stats.Download},
+		{"upload", t.UploadTarget, stats.Upload},
+	} {
+		if c.crossing == nil || c.results == nil {
+			continue
+		}
+		current := c.results.GetBandWithInMbps()
+		crossed, previous := c.crossing.update(current)
+		if !crossed {
+			continue
+		}
+		reason, side := AlertReasonCrossedAbove, "above"
+		if c.crossing.below {
+			reason, side = AlertReasonCrossedBelow, "below"
+		}
+		message := fmt.Sprintf("%s rate crossed %s the target of %.2f Mbps: %.2f -> %.2f Mbps", c.direction, side, c.crossing.Target, previous, current)
+		logger.Println(message)
+		if t.Alerts != nil {
+			t.Alerts.Send(logger, t.completeAlert(Alert{
+				Reason:      reason,
+				Message:     message,
+				RunID:       stats.RunID,
+				Direction:   c.direction,
+				Target:      c.crossing.Target,
+				Previous:    previous,
+				Current:     current,
+				Annotations: stats.Annotations,
+			}))
+		}
+	}
+}
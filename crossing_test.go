@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCrossingUpdate(t *testing.T) {
+	c := &crossing{Target: 50}
+	steps := []struct {
+		mbps     float64
+		crossed  bool
+		previous float64
+	}{
+		{80, false, 0}, // The first rate only sets the side
+		{60, false, 80},
+		{40, true, 60},
+		{30, false, 40},
+		{50, true, 30}, // Equal to the target is above it
+		{70, false, 50},
+		{10, true, 70},
+	}
+	for i, step := range steps {
+		crossed, previous := c.update(step.mbps)
+		if crossed != step.crossed || previous != step.previous {
+			t.Errorf("update %d (%v Mbps) = %t, %v, want %t, %v", i+1, step.mbps, crossed, previous, step.crossed, step.previous)
+		}
+	}
+}
+
+func TestCheckCrossings(t *testing.T) {
+	receiver, url := newAlertReceiver(t, 200)
+	alerts, err := NewAlertWebhook(url, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester := &SpeedTester{Alerts: alerts, DownloadTarget: &crossing{Target: 50}, UploadTarget: &crossing{Target: 10}}
+	// Rates in Mbps of the download and upload of each run.
+	runs := [][2]float64{{100, 20}, {40, 20}, {30, 5}, {60, 5}, {60, 5}}
+	for i, rates := range runs {
+		stats := loadResult(t, "result.json")
+		stats.RunID = string(rune('a' + i))
+		stats.Download.Bandwidth = int64(rates[0] * 125000)
+		stats.Upload.Bandwidth = int64(rates[1] * 125000)
+		tester.checkCrossings(testLogger(t), stats)
+	}
+	want := []Alert{
+		{Reason: AlertReasonCrossedBelow, RunID: "b", Direction: "download", Target: 50, Previous: 100, Current: 40},
+		{Reason: AlertReasonCrossedBelow, RunID: "c", Direction: "upload", Target: 10, Previous: 20, Current: 5},
+		{Reason: AlertReasonCrossedAbove, RunID: "d", Direction: "download", Target: 50, Previous: 30, Current: 60},
+	}
+	got := receiver.Alerts()
+	if len(got) != len(want) {
+		t.Fatalf("got %d alerts, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.Reason != w.Reason || g.RunID != w.RunID || g.Direction != w.Direction || g.Target != w.Target || g.Previous != w.Previous || g.Current != w.Current {
+			t.Errorf("alert %d = %+v, want %+v", i+1, g, w)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// DogStatsDSink sends the results of each run as DogStatsD gauges over UDP, using the labels as tags.
+type DogStatsDSink struct {
+	Prefix string
+	conn   net.Conn
+}
+
+// NewDogStatsDSink creates a sink sending to the DogStatsD agent at the given host:port.
+func NewDogStatsDSink(address string) (Sink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &DogStatsDSink{Prefix: "speedtest.", conn: conn}, nil
+}
+
+func (s *DogStatsDSink) Name() string {
+	return "dogstatsd"
+}
+
+func (s *DogStatsDSink) Publish(stats *Stats) error {
+	var errs []string
+	for _, line := range dogStatsDLines(s.Prefix, stats) {
+		if _, err := s.conn.Write([]byte(line)); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("cannot send %d metrics: %s", len(errs), errs[0])
+	}
+	return nil
+}
+
+// dogStatsDLines formats the results as gauges, e.g. speedtest.download_mbps:95.3|g|#isp:acme,server_id:1234
+func dogStatsDLines(prefix string, stats *Stats) []string {
+	var tags []string
+	for name, value := range stats.Labels() {
+		if value != "" {
+			tags = append(tags, name+":"+dogStatsDEscape(value))
+		}
+	}
+	slices.Sort(tags)
+	var lines []string
+	for name, value := range stats.ToMap() {
+		line := fmt.Sprintf("%s%s:%g|g", prefix, name, value)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		lines = append(lines, line)
+	}
+	slices.Sort(lines)
+	return lines
+}
+
+// dogStatsDEscape replaces the characters that have a special meaning on the DogStatsD protocol.
+func dogStatsDEscape(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", " ").Replace(value)
+}
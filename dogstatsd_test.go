@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDogStatsDLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats *Stats
+		want  []string
+	}{
+		{
+			name: "tagged",
+			stats: &Stats{
+				Server:   &ServerInfo{ID: 1234, Name: "Acme, Inc.", Location: "New York|NY"},
+				Ping:     &PingStats{Latency: 8.5, Jitter: 0.25},
+				Download: &BandwidthStats{Bandwidth: 12500000},
+				ISP:      "Acme #1",
+				Method:   MethodFallback,
+			},
+			want: []string{
+				"speedtest.download_mbps:100|g|#isp:Acme _1,method:http-fallback,server_id:1234,server_location:New York_NY,server_name:Acme_ Inc.",
+				"speedtest.ping_jitter_ms:0.25|g|#isp:Acme _1,method:http-fallback,server_id:1234,server_location:New York_NY,server_name:Acme_ Inc.",
+				"speedtest.ping_latency_ms:8.5|g|#isp:Acme _1,method:http-fallback,server_id:1234,server_location:New York_NY,server_name:Acme_ Inc.",
+			},
+		},
+		{
+			name:  "empty labels are omitted",
+			stats: &Stats{Ping: &PingStats{Latency: 8.5}},
+			want:  []string{"speedtest.ping_jitter_ms:0|g", "speedtest.ping_latency_ms:8.5|g"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dogStatsDLines("speedtest.", tt.stats); !slices.Equal(got, tt.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDogStatsDSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sink, err := NewDogStatsDSink(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := loadResult(t, "result.json")
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each gauge is sent on its own packet.
+	want := dogStatsDLines("speedtest.", stats)
+	var got []string
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for range want {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("got packets:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, packet := range got {
+		if !strings.HasPrefix(packet, "speedtest.") || !strings.Contains(packet, "|g|#") || !strings.Contains(packet, "isp:Acme") {
+			t.Errorf("unexpected packet %q", packet)
+		}
+	}
+}
+
+func TestDogStatsDSinkError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink, err := NewDogStatsDSink(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	sink.(*DogStatsDSink).conn.Close()
+	if err := sink.Publish(loadResult(t, "result.json")); err == nil || !strings.Contains(err.Error(), "cannot send") {
+		t.Errorf("got error %v, want one about the metrics that cannot be sent", err)
+	}
+}
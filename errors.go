@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Errors returned by the runs, used to derive the failure reasons.
+var (
+	ErrCLIUnavailable = errors.New("speedtest CLI unavailable")
+	ErrExec           = errors.New("speedtest failed")
+	ErrTimeout        = errors.New("speedtest timed out")
+	ErrParse          = errors.New("cannot parse results")
+	ErrValidation     = errors.New("invalid results")
+	ErrServerNotFound = errors.New("server not found")
+	ErrUnreachable    = errors.New("cannot connect to the server")
+	ErrBusy           = errors.New("a run is already in progress")
+	ErrDeduplicated   = errors.New("test skipped, results published within the dedup window")
+	ErrSkippedSlow    = errors.New("test skipped, idle latency above the threshold")
+	ErrTooShort       = errors.New("test too short to be reliable")
+)
+
+// Failure reasons, in order of precedence, exposed via the reason label of speedtest_failures_total.
+var failureReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrSkippedSlow, "skipped_slow"},
+	{ErrServerNotFound, "server_not_found"},
+	{ErrUnreachable, "unreachable"},
+	{ErrCLIUnavailable, "unavailable"},
+	{ErrTimeout, "timeout"},
+	{ErrParse, "parse"},
+	{ErrValidation, "validation"},
+	{ErrTooShort, "too_short"},
+	{ErrExec, "exec"},
+}
+
+// ParseFailureReasons validates a list of failure reasons.
+func ParseFailureReasons(values []string) ([]string, error) {
+	var names []string
+	for _, r := range failureReasons {
+		names = append(names, r.reason)
+	}
+	for _, v := range values {
+		if !slices.Contains(names, v) {
+			return nil, fmt.Errorf("unknown failure reason %q, expected one of %s", v, strings.Join(names, ", "))
+		}
+	}
+	return values, nil
+}
+
+// Messages printed by the CLI when the requested server ID doesn't exist.
+var serverNotFoundRegex = regexp.MustCompile(`(?i)no servers? (with id|defined|found)|server .*not found|NoServersException`)
+
+// Messages printed by the CLI when it cannot connect to the server.
+var unreachableRegex = regexp.MustCompile(`(?i)cannot open socket|timeout occurred in connect|connection refused|network is unreachable|no route to host|cannot resolve|could not resolve`)
+
+// FailureReason returns the reason of a failed run.
+func FailureReason(err error) string {
+	for _, r := range failureReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestServerNotFoundRegex(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"[error] No servers defined (NoServersException)", true},
+		{"[error] Error: [0] No server with id 99999", true},
+		{"No servers found", true},
+		{"[error] Server 99999 not found", true},
+		{"[error] Error: [111] Cannot open socket: Connection refused", false},
+		{"[error] Configuration - Couldn't resolve host name (HostNotFoundException)", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := serverNotFoundRegex.MatchString(tt.stderr); got != tt.want {
+			t.Errorf("serverNotFoundRegex.MatchString(%q) = %t, want %t", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("%w: exit status 1", ErrServerNotFound), "server_not_found"},
+		{fmt.Errorf("%w: exit status 1: %w", ErrExec, ErrServerNotFound), "server_not_found"},
+		{fmt.Errorf("%w after 1m0s", ErrTimeout), "timeout"},
+		{fmt.Errorf("%w: exit status 1", ErrExec), "exec"},
+		{ErrSkippedSlow, "skipped_slow"},
+		{errors.New("something else"), "unknown"},
+	}
+	for _, tt := range tests {
+		if got := FailureReason(tt.err); got != tt.want {
+			t.Errorf("FailureReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseFailureReasons(t *testing.T) {
+	tests := []struct {
+		values  []string
+		wantErr bool
+	}{
+		{values: nil},
+		{values: []string{"timeout", "exec"}},
+		{values: []string{"unavailable", "server_not_found", "too_short"}},
+		{values: []string{"timeout", "flaky"}, wantErr: true},
+		{values: []string{"Timeout"}, wantErr: true},
+		{values: []string{"error"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFailureReasons(tt.values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseFailureReasons(%v) got error %v, want an error: %t", tt.values, err, tt.wantErr)
+			continue
+		}
+		if err == nil && fmt.Sprint(got) != fmt.Sprint(tt.values) {
+			t.Errorf("ParseFailureReasons(%v) = %v", tt.values, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of the events written by the EventWriter.
+const (
+	EventResult = "result"
+	EventError  = "error"
+	EventLog    = "log"
+)
+
+// Event is a line of the NDJSON stream, with the fields set according to its type.
+type Event struct {
+	Type                string             `json:"type"`
+	Time                time.Time          `json:"time"`
+	RunID               string             `json:"runId,omitempty"`
+	Labels              map[string]string  `json:"labels,omitempty"`  // result
+	Metrics             map[string]float64 `json:"metrics,omitempty"` // result, indexed like the sinks
+	Annotations         map[string]string  `json:"annotations,omitempty"`
+	Reason              string             `json:"reason,omitempty"` // error
+	ConsecutiveFailures int                `json:"consecutiveFailures,omitempty"`
+	Message             string             `json:"message,omitempty"` // error and log
+}
+
+// EventWriter writes the results, the errors, and the logs of the runs as a single
+// NDJSON stream, one object per event, for log-centric stacks without a scraper.
+// As an io.Writer, it wraps each line written by a logger into a log event.
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewEventWriter creates an event writer on the given output, usually stdout.
+func NewEventWriter(w io.Writer, now func() time.Time) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), now: now}
+}
+
+// Write implements io.Writer for the loggers, which write one line per call. The
+// run=<id> prefix of the loggers of the runs is moved to the runId field.
+func (w *EventWriter) Write(p []byte) (int, error) {
+	event := Event{Type: EventLog, Message: strings.TrimSuffix(string(p), "\n")}
+	if rest, ok := strings.CutPrefix(event.Message, "run="); ok {
+		event.RunID, event.Message, _ = strings.Cut(rest, " ")
+	}
+	if err := w.Emit(event); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Result writes the event of a successful run.
+func (w *EventWriter) Result(stats *Stats) error {
+	return w.Emit(Event{
+		Type:        EventResult,
+		RunID:       stats.RunID,
+		Labels:      stats.Labels(),
+		Metrics:     stats.ToMap(),
+		Annotations: stats.Annotations,
+	})
+}
+
+// Error writes the event of a failed run.
+func (w *EventWriter) Error(runID string, err error, failures int, annotations map[string]string) error {
+	return w.Emit(Event{
+		Type:                EventError,
+		RunID:               runID,
+		Reason:              FailureReason(err),
+		Message:             err.Error(),
+		ConsecutiveFailures: failures,
+		Annotations:         annotations,
+	})
+}
+
+// Emit writes an event, setting its time when missing.
+func (w *EventWriter) Emit(event Event) error {
+	if event.Time.IsZero() {
+		event.Time = w.now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(event)
+}
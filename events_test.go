@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeEvents decodes each line of an NDJSON stream as a generic object.
+func decodeEvents(t *testing.T, data string) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		var event map[string]any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestEventWriter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	stats := loadResult(t, "result.json")
+	stats.RunID = "r1"
+	stats.Method = MethodOokla
+	stats.Annotations = map[string]string{"reason": "manual"}
+	tests := []struct {
+		name   string
+		emit   func(*EventWriter) error
+		fields []string       // Expected fields, sorted
+		want   map[string]any // Expected values of some of the fields
+	}{
+		{
+			name:   "result",
+			emit:   func(w *EventWriter) error { return w.Result(stats) },
+			fields: []string{"annotations", "labels", "metrics", "runId", "time", "type"},
+			want:   map[string]any{"type": EventResult, "runId": "r1", "time": "2024-06-01T10:00:00Z"},
+		},
+		{
+			name:   "error",
+			emit:   func(w *EventWriter) error { return w.Error("r2", fmt.Errorf("%w after 1m0s", ErrTimeout), 3, nil) },
+			fields: []string{"consecutiveFailures", "message", "reason", "runId", "time", "type"},
+			want:   map[string]any{"type": EventError, "runId": "r2", "reason": "timeout", "message": "speedtest timed out after 1m0s", "consecutiveFailures": 3.0},
+		},
+		{
+			name: "log",
+			emit: func(w *EventWriter) error {
+				_, err := w.Write([]byte("Starting the exporter\n"))
+				return err
+			},
+			fields: []string{"message", "time", "type"},
+			want:   map[string]any{"type": EventLog, "message": "Starting the exporter"},
+		},
+		{
+			name: "log of a run",
+			emit: func(w *EventWriter) error {
+				_, err := w.Write([]byte("run=r3 Running test 1 of 2\n"))
+				return err
+			},
+			fields: []string{"message", "runId", "time", "type"},
+			want:   map[string]any{"type": EventLog, "runId": "r3", "message": "Running test 1 of 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := tt.emit(NewEventWriter(&out, func() time.Time { return now })); err != nil {
+				t.Fatal(err)
+			}
+			events := decodeEvents(t, out.String())
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			var fields []string
+			for field := range events[0] {
+				fields = append(fields, field)
+			}
+			slices.Sort(fields)
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("got the fields %v, want %v", fields, tt.fields)
+			}
+			for field, want := range tt.want {
+				if got := events[0][field]; got != want {
+					t.Errorf("%s = %v, want %v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEventWriterResult(t *testing.T) {
+	stats := loadResult(t, "result.json")
+	var out bytes.Buffer
+	if err := NewEventWriter(&out, time.Now).Result(stats); err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if got := event.Labels["server_name"]; got != "UNC Chapel Hill" {
+		t.Errorf("labels.server_name = %q, want UNC Chapel Hill", got)
+	}
+	if got := event.Metrics["download_mbps"]; got != 100 {
+		t.Errorf("metrics.download_mbps = %v, want 100", got)
+	}
+}
+
+func TestRunEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		cli  func(*testing.T) string
+		want []string // Expected types of the events
+	}{
+		{name: "success", cli: func(t *testing.T) string { return fixtureCLI(t, "result.json") }, want: []string{EventResult}},
+		{name: "failure", cli: func(t *testing.T) string { return fakeCLI(t, "exit 1") }, want: []string{EventError}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tester := &SpeedTester{Command: tt.cli(t), Events: NewEventWriter(&out, time.Now)}
+			tester.Metrics()
+			tester.Run()
+			var types []string
+			for _, event := range decodeEvents(t, out.String()) {
+				types = append(types, event["type"].(string))
+			}
+			if !slices.Equal(types, tt.want) {
+				t.Errorf("got the events %v, want %v", types, tt.want)
+			}
+		})
+	}
+}
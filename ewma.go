@@ -0,0 +1,59 @@
+package main
+
+import "strings"
+
+// Default weight of the latest run on the moving averages.
+const defaultEWMAAlpha = 0.3
+
+// ewma is an exponentially weighted moving average, where alpha is the weight of
+// each new value (between 0 and 1; the higher, the less smoothing).
+type ewma struct {
+	alpha float64
+	value float64
+	set   bool
+}
+
+// Add includes a value on the average and returns the result. The first value
+// initializes the average.
+func (e *ewma) Add(v float64) float64 {
+	if !e.set {
+		e.value, e.set = v, true
+	} else {
+		e.value = e.alpha*v + (1-e.alpha)*e.value
+	}
+	return e.value
+}
+
+// updateAverages adds the results to the moving averages of its series and publishes them.
+// Called while holding the running lock, so the state needs no extra synchronization.
+func (t *SpeedTester) updateAverages(stats *Stats) {
+	if stats.Server == nil {
+		return
+	}
+	if t.averages == nil {
+		t.averages = make(map[string]*ewma)
+	}
+	labels := t.promStats.labelValues(stats)
+	add := func(metric string, v float64) float64 {
+		key := metric + "\xff" + strings.Join(labels, "\xff")
+		avg, ok := t.averages[key]
+		if !ok {
+			alpha := t.EWMAAlpha
+			if alpha <= 0 || alpha > 1 {
+				alpha = defaultEWMAAlpha
+			}
+			avg = &ewma{alpha: alpha}
+			t.averages[key] = avg
+		}
+		return avg.Add(v)
+	}
+	if stats.Download != nil {
+		t.promStats.DownloadEWMA.WithLabelValues(labels...).Set(add("download", stats.Download.GetBandWithInMbps()))
+	}
+	if stats.Upload != nil {
+		t.promStats.UploadEWMA.WithLabelValues(labels...).Set(add("upload", stats.Upload.GetBandWithInMbps()))
+	}
+	if stats.Ping != nil {
+		t.promStats.PingEWMA.WithLabelValues(labels...).Set(add("ping", stats.Ping.Latency))
+	}
+}
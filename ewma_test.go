@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEWMA(t *testing.T) {
+	tests := []struct {
+		name   string
+		alpha  float64
+		values []float64
+		want   []float64 // Expected average after each value
+	}{
+		{"first value", 0.3, []float64{100}, []float64{100}},
+		{"smoothing", 0.5, []float64{100, 50, 50, 150}, []float64{100, 75, 62.5, 106.25}},
+		{"default alpha", defaultEWMAAlpha, []float64{100, 0, 100}, []float64{100, 70, 79}},
+		{"no smoothing", 1, []float64{100, 20, 60}, []float64{100, 20, 60}},
+		{"constant", 0.1, []float64{42, 42, 42}, []float64{42, 42, 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &ewma{alpha: tt.alpha}
+			for i, v := range tt.values {
+				if got := e.Add(v); math.Abs(got-tt.want[i]) > 1e-9 {
+					t.Errorf("after adding %v, got %v, want %v", tt.values[:i+1], got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateAverages(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha float64
+		want  float64 // Expected download average after 100 and 50 Mbps
+	}{
+		{"configured", 0.5, 75},
+		{"invalid uses the default", 0, 85},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{EWMAAlpha: tt.alpha}
+			metrics := tester.Metrics()
+			one := &Stats{Server: &ServerInfo{ID: 1}, Method: MethodOokla, Download: &BandwidthStats{Bandwidth: 12500000}, Ping: &PingStats{Latency: 10}}
+			other := &Stats{Server: &ServerInfo{ID: 2}, Method: MethodOokla, Download: &BandwidthStats{Bandwidth: 1250000}}
+			tester.updateAverages(one)
+			tester.updateAverages(other)
+			one.Download.Bandwidth = 6250000
+			one.Ping.Latency = 20
+			tester.updateAverages(one)
+
+			labels := metrics.labelValues(one)
+			if got := testutil.ToFloat64(metrics.DownloadEWMA.WithLabelValues(labels...)); got != tt.want {
+				t.Errorf("speedtest_download_mbps_ewma = %v, want %v", got, tt.want)
+			}
+			wantPing := 10 + (20-10)*tt.alpha
+			if tt.alpha == 0 {
+				wantPing = 10 + (20-10)*defaultEWMAAlpha
+			}
+			if got := testutil.ToFloat64(metrics.PingEWMA.WithLabelValues(labels...)); math.Abs(got-wantPing) > 1e-9 {
+				t.Errorf("speedtest_ping_latency_ms_ewma = %v, want %v", got, wantPing)
+			}
+			// Each series has its own average.
+			if got := testutil.ToFloat64(metrics.DownloadEWMA.WithLabelValues(metrics.labelValues(other)...)); got != 10 {
+				t.Errorf("speedtest_download_mbps_ewma of the other server = %v, want 10", got)
+			}
+			if got := testutil.CollectAndCount(metrics.UploadEWMA); got != 0 {
+				t.Errorf("got %d upload averages without upload results, want 0", got)
+			}
+		})
+	}
+}
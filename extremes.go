@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// extremes are the lowest and highest download rates of a series.
+type extremes struct {
+	min float64
+	max float64
+}
+
+// updateExtremes tracks the lowest and highest download rates per series over the
+// process lifetime, since gauges cannot be read back.
+func (s *PrometheusStats) updateExtremes(labels []string, mbps float64) {
+	s.extremesMu.Lock()
+	defer s.extremesMu.Unlock()
+	if s.extremes == nil {
+		s.extremes = make(map[string]extremes)
+	}
+	key := strings.Join(labels, "\xff")
+	e, ok := s.extremes[key]
+	if !ok {
+		e = extremes{min: mbps, max: mbps}
+	}
+	e.min = min(e.min, mbps)
+	e.max = max(e.max, mbps)
+	s.extremes[key] = e
+	s.DownloadMin.WithLabelValues(labels...).Set(e.min)
+	s.DownloadMax.WithLabelValues(labels...).Set(e.max)
+}
+
+// ResetExtremes forgets the lowest and highest download rates, starting over with the next run.
+func (s *PrometheusStats) ResetExtremes() {
+	s.extremesMu.Lock()
+	defer s.extremesMu.Unlock()
+	s.extremes = nil
+	s.DownloadMin.Reset()
+	s.DownloadMax.Reset()
+}
+
+// ResetExtremesHandler returns an HTTP handler to reset the lowest and highest download rates (POST only).
+func (t *SpeedTester) ResetExtremesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		t.Metrics().ResetExtremes()
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
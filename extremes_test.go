@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdateExtremes(t *testing.T) {
+	type run struct {
+		server int
+		mbps   float64
+	}
+	tests := []struct {
+		name     string
+		runs     []run
+		min, max map[int]float64 // Expected extremes per server
+	}{
+		{
+			name: "single run",
+			runs: []run{{1, 100}},
+			min:  map[int]float64{1: 100},
+			max:  map[int]float64{1: 100},
+		},
+		{
+			name: "several runs",
+			runs: []run{{1, 100}, {1, 80}, {1, 120}, {1, 90}},
+			min:  map[int]float64{1: 80},
+			max:  map[int]float64{1: 120},
+		},
+		{
+			name: "per server",
+			runs: []run{{1, 100}, {2, 40}, {1, 60}, {2, 50}},
+			min:  map[int]float64{1: 60, 2: 40},
+			max:  map[int]float64{1: 100, 2: 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &PrometheusStats{}
+			s.Init()
+			servers := make(map[int]*Stats)
+			for _, r := range tt.runs {
+				stats := &Stats{Server: &ServerInfo{ID: r.server, Name: "Server"}, ISP: "Acme", Method: MethodOokla, Download: &BandwidthStats{Bandwidth: int64(r.mbps * 1e6 / 8)}}
+				servers[r.server] = stats
+				s.Update(stats)
+			}
+			for id, stats := range servers {
+				labels := s.labelValues(stats)
+				if got := testutil.ToFloat64(s.DownloadMin.WithLabelValues(labels...)); got != tt.min[id] {
+					t.Errorf("speedtest_download_mbps_min for server %d = %v, want %v", id, got, tt.min[id])
+				}
+				if got := testutil.ToFloat64(s.DownloadMax.WithLabelValues(labels...)); got != tt.max[id] {
+					t.Errorf("speedtest_download_mbps_max for server %d = %v, want %v", id, got, tt.max[id])
+				}
+			}
+		})
+	}
+}
+
+func TestResetExtremesHandler(t *testing.T) {
+	tester := &SpeedTester{}
+	metrics := tester.Metrics()
+	stats := &Stats{Server: &ServerInfo{ID: 1}, Method: MethodOokla, Download: &BandwidthStats{Bandwidth: 12500000}}
+	metrics.Update(stats)
+
+	rec := httptest.NewRecorder()
+	tester.ResetExtremesHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reset-extremes", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for GET, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if got := testutil.CollectAndCount(metrics.DownloadMax); got != 1 {
+		t.Fatalf("got %d series after a GET, want 1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	tester.ResetExtremesHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset-extremes", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if got := testutil.CollectAndCount(metrics.DownloadMin) + testutil.CollectAndCount(metrics.DownloadMax); got != 0 {
+		t.Errorf("got %d series after the reset, want 0", got)
+	}
+
+	// The next run starts over.
+	stats.Download.Bandwidth = 6250000
+	metrics.Update(stats)
+	labels := metrics.labelValues(stats)
+	if got := testutil.ToFloat64(metrics.DownloadMax.WithLabelValues(labels...)); got != 50 {
+		t.Errorf("speedtest_download_mbps_max = %v after the reset, want 50", got)
+	}
+}
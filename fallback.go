@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Number of requests used to measure the latency against the fallback URL.
+const fallbackPings = 5
+
+// HTTPSpeedTest measures the download throughput and the latency against an HTTP server,
+// used as a fallback when the speedtest CLI is unavailable.
+type HTTPSpeedTest struct {
+	URL     *url.URL
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+// NewHTTPSpeedTest creates a fallback test that downloads the file at the given URL.
+func NewHTTPSpeedTest(rawURL string, timeout time.Duration) (*HTTPSpeedTest, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL")
+	}
+	return &HTTPSpeedTest{URL: u, Timeout: timeout, Client: &http.Client{}}, nil
+}
+
+// Run measures the latency and the download throughput, returning a reduced set of results.
+func (h *HTTPSpeedTest) Run(logger *log.Logger) (*Stats, error) {
+	start := time.Now()
+	ctx := context.Background()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	ping, err := h.ping(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot measure latency: %w", err)
+	}
+	download, cacheHit, err := h.download(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot measure download: %w", err)
+	}
+	if cacheHit {
+		logger.Printf("WARNING: the fallback download was served from a cache, the results may be inflated")
+	}
+
+	stats := &Stats{
+		Server:   &ServerInfo{Host: h.URL.Host, Name: h.URL.Hostname()},
+		Ping:     ping,
+		Download: download,
+		Method:   MethodFallback,
+		CacheHit: cacheHit,
+	}
+	stats.Log(logger)
+	logger.Printf("Finished in %s", time.Since(start).String())
+	return stats, nil
+}
+
+// ping measures the time to the first byte of HEAD requests. As the connection is reused,
+// only the first sample includes the handshakes.
+func (h *HTTPSpeedTest) ping(ctx context.Context) (*PingStats, error) {
+	var samples []float64
+	for range fallbackPings {
+		var sent, received time.Time
+		trace := &httptrace.ClientTrace{
+			WroteRequest:         func(httptrace.WroteRequestInfo) { sent = time.Now() },
+			GotFirstResponseByte: func() { received = time.Now() },
+		}
+		req, err := h.newRequest(httptrace.WithClientTrace(ctx, trace), http.MethodHead)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		samples = append(samples, float64(received.Sub(sent).Microseconds())/1000)
+	}
+	return pingStats(samples), nil
+}
+
+// download fetches the whole file, measuring the throughput in bytes per second like the CLI,
+// and reporting whether the response headers indicate that it was served from a cache.
+func (h *HTTPSpeedTest) download(ctx context.Context) (*BandwidthStats, bool, error) {
+	req, err := h.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, false, err
+	}
+	start := time.Now()
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	elapsed := time.Since(start)
+	return &BandwidthStats{
+		Bandwidth: int64(float64(n) / elapsed.Seconds()),
+		Bytes:     n,
+		Elapsed:   elapsed.Milliseconds(),
+	}, isCacheHit(resp.Header), nil
+}
+
+// newRequest creates a request with a cache-busting query parameter and headers,
+// to avoid measuring content served by an intermediate cache.
+func (h *HTTPSpeedTest) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	u := *h.URL
+	query := u.Query()
+	query.Set("nocache", strconv.FormatInt(time.Now().UnixNano(), 10))
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+	return req, nil
+}
+
+// isCacheHit inspects the headers commonly set by CDNs and caching proxies.
+func isCacheHit(header http.Header) bool {
+	for _, name := range []string{"X-Cache", "X-Cache-Status", "CF-Cache-Status", "X-Proxy-Cache"} {
+		if strings.Contains(strings.ToUpper(header.Get(name)), "HIT") {
+			return true
+		}
+	}
+	age, err := strconv.Atoi(header.Get("Age"))
+	return err == nil && age > 0
+}
+
+// pingStats computes the average, minimum, maximum, and jitter (mean difference between consecutive samples).
+func pingStats(samples []float64) *PingStats {
+	p := &PingStats{Low: math.Inf(1), High: math.Inf(-1)}
+	var sum, jitter float64
+	for i, s := range samples {
+		sum += s
+		p.Low = min(p.Low, s)
+		p.High = max(p.High, s)
+		if i > 0 {
+			jitter += math.Abs(s - samples[i-1])
+		}
+	}
+	p.Latency = sum / float64(len(samples))
+	if len(samples) > 1 {
+		p.Jitter = jitter / float64(len(samples)-1)
+	}
+	return p
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewHTTPSpeedTest(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr bool
+	}{
+		{raw: "http://speed.example.com/10MB.bin"},
+		{raw: "https://speed.example.com/10MB.bin"},
+		{raw: "ftp://speed.example.com/10MB.bin", wantErr: true},
+		{raw: "speed.example.com/10MB.bin", wantErr: true},
+		{raw: "https:///10MB.bin", wantErr: true},
+		{raw: "http://speed example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			if _, err := NewHTTPSpeedTest(tt.raw, time.Minute); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fallbackServer serves a file of the given size, counting the requests by method.
+func fallbackServer(t *testing.T, size int, header http.Header) (*httptest.Server, map[string]*atomic.Int32) {
+	t.Helper()
+	counts := map[string]*atomic.Int32{http.MethodHead: new(atomic.Int32), http.MethodGet: new(atomic.Int32)}
+	content := bytes.Repeat([]byte{'x'}, size)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		counts[r.Method].Add(1)
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, counts
+}
+
+func TestHTTPSpeedTestRun(t *testing.T) {
+	server, counts := fallbackServer(t, 1<<20, nil)
+	test, err := NewHTTPSpeedTest(server.URL+"/file.bin", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := test.Run(testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Method != MethodFallback || stats.Server.Name != "127.0.0.1" || stats.Upload != nil {
+		t.Errorf("got method %q, server %q, and upload %v", stats.Method, stats.Server.Name, stats.Upload)
+	}
+	if stats.Download.Bytes != 1<<20 || stats.Download.Bandwidth <= 0 {
+		t.Errorf("downloaded %d bytes at %d B/s, want %d bytes", stats.Download.Bytes, stats.Download.Bandwidth, 1<<20)
+	}
+	if stats.Ping.Latency <= 0 || stats.Ping.Low > stats.Ping.Latency || stats.Ping.High < stats.Ping.Latency {
+		t.Errorf("got inconsistent ping results %+v", stats.Ping)
+	}
+	if got := counts[http.MethodHead].Load(); got != fallbackPings {
+		t.Errorf("got %d HEAD requests, want %d", got, fallbackPings)
+	}
+	if got := counts[http.MethodGet].Load(); got != 1 {
+		t.Errorf("got %d GET requests, want 1", got)
+	}
+}
+
+func TestHTTPSpeedTestErrors(t *testing.T) {
+	server, _ := fallbackServer(t, 1024, nil)
+	tests := []struct {
+		name    string
+		url     string
+		wantErr string
+	}{
+		{"not found", server.URL + "/missing.bin", "unexpected status 404"},
+		{"unreachable", "http://127.0.0.1:1/file.bin", "cannot measure latency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test, err := NewHTTPSpeedTest(tt.url, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := test.Run(testLogger(t)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPingStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []float64
+		want    PingStats
+	}{
+		{"single", []float64{12}, PingStats{Latency: 12, Low: 12, High: 12}},
+		{"constant", []float64{10, 10, 10}, PingStats{Latency: 10, Low: 10, High: 10}},
+		{"varying", []float64{30, 10, 20, 20}, PingStats{Latency: 20, Low: 10, High: 30, Jitter: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pingStats(tt.samples); !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFallback(t *testing.T) {
+	server, _ := fallbackServer(t, 1<<16, nil)
+	fallback, err := NewHTTPSpeedTest(server.URL+"/file.bin", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tester := &SpeedTester{Command: filepath.Join(t.TempDir(), "speedtest"), Fallback: fallback}
+	tester.Metrics()
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tester.Latest().Method; got != MethodFallback {
+		t.Errorf("got the results of method %q, want %q when the CLI is unavailable", got, MethodFallback)
+	}
+}
+
+func TestIsCacheHit(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{}, false},
+		{http.Header{"X-Cache": {"HIT from proxy.example.com"}}, true},
+		{http.Header{"X-Cache": {"MISS from proxy.example.com"}}, false},
+		{http.Header{"X-Cache-Status": {"hit"}}, true},
+		{http.Header{"Cf-Cache-Status": {"DYNAMIC"}}, false},
+		{http.Header{"Cf-Cache-Status": {"HIT"}}, true},
+		{http.Header{"X-Proxy-Cache": {"HIT"}}, true},
+		{http.Header{"Age": {"0"}}, false},
+		{http.Header{"Age": {"120"}}, true},
+		{http.Header{"Age": {"unknown"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.header), func(t *testing.T) {
+			if got := isCacheHit(tt.header); got != tt.want {
+				t.Errorf("isCacheHit(%v) = %t, want %t", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPSpeedTestCacheBusting(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		queries  = make(map[string]bool)
+		requests int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		queries[r.URL.Query().Get("nocache")] = true
+		noCache := r.Header.Get("Cache-Control") == "no-cache" && r.Header.Get("Pragma") == "no-cache"
+		mu.Unlock()
+		if !noCache || r.URL.Query().Get("size") != "1M" {
+			http.Error(w, "missing the cache-busting headers or query", http.StatusBadRequest)
+			return
+		}
+		w.Write(bytes.Repeat([]byte{'x'}, 1024))
+	}))
+	t.Cleanup(server.Close)
+
+	test, err := NewHTTPSpeedTest(server.URL+"/file.bin?size=1M", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.Run(testLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queries) != requests || queries[""] {
+		t.Errorf("got %d distinct cache-busting values for %d requests: %v", len(queries), requests, queries)
+	}
+}
+
+func TestRunFallbackCacheHit(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   float64
+	}{
+		{"fresh", http.Header{"X-Cache": {"MISS"}}, 0},
+		{"cached by a CDN", http.Header{"Cf-Cache-Status": {"HIT"}}, 1},
+		{"cached by a proxy", http.Header{"Age": {"30"}}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := fallbackServer(t, 1<<16, tt.header)
+			fallback, err := NewHTTPSpeedTest(server.URL+"/file.bin", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tester := &SpeedTester{Command: filepath.Join(t.TempDir(), "speedtest"), Fallback: fallback}
+			metrics := tester.Metrics()
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tester.Latest().CacheHit; got != (tt.want == 1) {
+				t.Errorf("got cacheHit %t, want %t", got, tt.want == 1)
+			}
+			if got := testutil.ToFloat64(metrics.FallbackCacheHit); got != tt.want {
+				t.Errorf("speedtest_fallback_cache_hit = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Optional families of metrics, which can be disabled to reduce the scrape size and
+// the storage on Prometheus when only some of the results matter.
+const (
+	MetricsLatency      = "latency"       // Download and upload latency
+	MetricsLatencyRange = "latency_range" // low and high series of all the latency metrics
+	MetricsJitter       = "jitter"        // Download, upload, and ping jitter
+	MetricsPacketLoss   = "packet_loss"   // Packet loss and its distribution
+	MetricsExtremes     = "extremes"      // Lowest and highest download rates
+	MetricsEWMA         = "ewma"          // Moving averages
+	MetricsElapsed      = "elapsed"       // Durations of the phases of the test
+)
+
+var metricFamilies = []string{MetricsLatency, MetricsLatencyRange, MetricsJitter, MetricsPacketLoss, MetricsExtremes, MetricsEWMA, MetricsElapsed}
+
+// ParseMetricFamilies validates the names of optional families of metrics.
+func ParseMetricFamilies(names []string) (map[string]bool, error) {
+	families := make(map[string]bool)
+	for _, name := range names {
+		if !slices.Contains(metricFamilies, name) {
+			return nil, fmt.Errorf("unknown family of metrics %q, expected one of %s", name, strings.Join(metricFamilies, ", "))
+		}
+		families[name] = true
+	}
+	return families, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseMetricFamilies(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{names: nil},
+		{names: []string{"jitter", "ewma"}, want: []string{"ewma", "jitter"}},
+		{names: []string{"jitter", "jitter"}, want: []string{"jitter"}},
+		{names: []string{"bandwidth"}, wantErr: true},
+		{names: []string{"Jitter"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.names, ","), func(t *testing.T) {
+			got, err := ParseMetricFamilies(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			var names []string
+			for name := range got {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("got %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisabledMetrics(t *testing.T) {
+	// Metrics of each family, and the latency series of the latency ranges.
+	families := map[string][]string{
+		MetricsLatency:      {"speedtest_download_latency", "speedtest_upload_latency"},
+		MetricsLatencyRange: {`latency="low"`, `latency="high"`},
+		MetricsJitter:       {"speedtest_download_jitter", "speedtest_upload_jitter", "speedtest_ping_jitter"},
+		MetricsPacketLoss:   {"speedtest_packet_loss", "speedtest_packet_loss_percent", "speedtest_probe_packet_loss"},
+		MetricsExtremes:     {"speedtest_download_mbps_min", "speedtest_download_mbps_max"},
+		MetricsEWMA:         {"speedtest_download_mbps_ewma", "speedtest_upload_mbps_ewma", "speedtest_ping_latency_ms_ewma"},
+		MetricsElapsed:      {"speedtest_phase_duration_ms"},
+	}
+	if len(families) != len(metricFamilies) {
+		t.Fatalf("got metrics for %d families, want %d", len(families), len(metricFamilies))
+	}
+	for _, disabled := range metricFamilies {
+		t.Run(disabled, func(t *testing.T) {
+			s := &PrometheusStats{DisabledMetrics: map[string]bool{disabled: true}}
+			s.Init()
+			populateMetrics(t, s)
+			exposition := gatherText(t, s)
+			for family, series := range families {
+				for _, name := range series {
+					if got, want := strings.Contains(exposition, name), family != disabled; got != want {
+						t.Errorf("exposed %s of the %s family: %t, want %t", name, family, got, want)
+					}
+				}
+			}
+			// The download and upload rates cannot be disabled.
+			for _, name := range []string{"speedtest_download_speed", "speedtest_upload_speed", `speedtest_ping_latency{`} {
+				if !strings.Contains(exposition, name) {
+					t.Errorf("%s is not exposed", name)
+				}
+			}
+		})
+	}
+}
+
+// gatherText returns the gathered metrics in the text exposition format.
+func gatherText(t *testing.T, s *PrometheusStats) string {
+	t.Helper()
+	families, err := s.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			b.WriteString(family.GetName() + "{")
+			for _, pair := range metric.GetLabel() {
+				b.WriteString(pair.GetName() + `="` + pair.GetValue() + `",`)
+			}
+			b.WriteString("}\n")
+		}
+	}
+	return b.String()
+}
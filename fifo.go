@@ -0,0 +1,47 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// FIFOSink writes a compact line describing each run to a named pipe.
+// Events are dropped when the pipe doesn't exist, has no reader, or is full.
+type FIFOSink struct {
+	Path string
+}
+
+// NewFIFOSink validates that the path, if it exists, is a named pipe.
+func NewFIFOSink(path string) (Sink, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode().Type() != fs.ModeNamedPipe {
+		return nil, fmt.Errorf("%s is not a named pipe", path)
+	}
+	return &FIFOSink{Path: path}, nil
+}
+
+func (s *FIFOSink) Name() string {
+	return "fifo"
+}
+
+func (s *FIFOSink) Publish(stats *Stats) error {
+	// Opening a FIFO for writing in non-blocking mode fails with ENXIO when there is no reader.
+	// The raw descriptor is used, as an os.File would wait for a full pipe to drain via the
+	// poller instead of failing with EAGAIN.
+	fd, err := syscall.Open(s.Path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return &fs.PathError{Op: "open", Path: s.Path, Err: err}
+	}
+	defer syscall.Close(fd)
+	if _, err := syscall.Write(fd, []byte(stats.Summary()+"\n")); err != nil && !errors.Is(err, syscall.EAGAIN) {
+		return &fs.PathError{Op: "write", Path: s.Path, Err: err}
+	}
+	return nil
+}
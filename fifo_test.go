@@ -0,0 +1,103 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewFIFOSink(t *testing.T) {
+	dir := t.TempDir()
+	regular := filepath.Join(dir, "regular")
+	if err := os.WriteFile(regular, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFIFOSink(regular); err == nil {
+		t.Error("expected an error for a regular file")
+	}
+	if _, err := NewFIFOSink(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("unexpected error for a missing path, it may be created later: %v", err)
+	}
+}
+
+func TestFIFOSinkPublish(t *testing.T) {
+	stats := loadResult(t, "result.json")
+	stats.RunID = "run-1"
+
+	t.Run("missing pipe", func(t *testing.T) {
+		sink := &FIFOSink{Path: filepath.Join(t.TempDir(), "missing")}
+		if err := sink.Publish(stats); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("without a reader", func(t *testing.T) {
+		sink := &FIFOSink{Path: makeFIFO(t)}
+		if err := sink.Publish(stats); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("with a reader", func(t *testing.T) {
+		path := makeFIFO(t)
+		reader := openReader(t, path)
+		sink := &FIFOSink{Path: path}
+		if err := sink.Publish(stats); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		buf := make([]byte, 4096)
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := stats.Summary() + "\n"; string(buf[:n]) != want {
+			t.Errorf("got %q, want %q", buf[:n], want)
+		}
+	})
+
+	t.Run("with a full pipe", func(t *testing.T) {
+		path := makeFIFO(t)
+		openReader(t, path) // Never read
+		sink := &FIFOSink{Path: path}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			// A pipe holds 64 KiB by default, so it fills up well before the end.
+			for range 1000 {
+				if err := sink.Publish(stats); err != nil {
+					t.Errorf("unexpected error: %v", err)
+					return
+				}
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("publishing to a full pipe blocked")
+		}
+	})
+}
+
+func makeFIFO(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skipf("cannot create a FIFO: %v", err)
+	}
+	return path
+}
+
+// openReader opens the FIFO for reading without waiting for a writer.
+func openReader(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
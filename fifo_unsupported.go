@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "fmt"
+
+// NewFIFOSink is not available on this platform.
+func NewFIFOSink(path string) (Sink, error) {
+	return nil, fmt.Errorf("named pipes are not supported on this platform")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/agalue/speedtester/speedtesterpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCSink streams the results of each run to a local collection agent implementing
+// the Collector service of speedtesterpb/speedtester.proto. The stream is kept open
+// across runs, and reopened when it breaks, e.g. after the agent restarts.
+type GRPCSink struct {
+	Address string
+	Timeout time.Duration // Maximum time to wait for the agent when opening the stream
+	client  speedtesterpb.CollectorClient
+
+	mu     sync.Mutex // Serializes the publishes, as a stream is not safe for concurrent sends
+	stream *grpcStream
+}
+
+// grpcStream is an open stream to the agent, watched to know when the agent ends it,
+// since sending on an ended stream can succeed without delivering the results.
+type grpcStream struct {
+	speedtesterpb.Collector_PublishClient
+	cancel context.CancelFunc
+	ended  chan struct{} // Closed when the stream ends
+	err    error         // Why the stream ended, set before closing ended
+}
+
+// NewGRPCSink creates a sink streaming to the agent at the given address, as host:port
+// or unix:///path/to/socket. The connection is established on the first publish.
+func NewGRPCSink(address string) (Sink, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCSink{
+		Address: address,
+		Timeout: 10 * time.Second,
+		client:  speedtesterpb.NewCollectorClient(conn),
+	}, nil
+}
+
+func (s *GRPCSink) Name() string {
+	return "grpc"
+}
+
+func (s *GRPCSink) Publish(stats *Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := grpcResult(stats, time.Now())
+	if s.stream != nil {
+		select {
+		case <-s.stream.ended:
+		default:
+			if err := s.stream.Send(result); err == nil {
+				return nil
+			}
+		}
+		// The stream is broken, so it is reopened to send the results again.
+		s.reset()
+	}
+	if err := s.open(); err != nil {
+		return fmt.Errorf("cannot open stream to %s: %w", s.Address, err)
+	}
+	if err := s.stream.Send(result); err != nil {
+		if cause := s.reset(); cause != nil {
+			err = cause
+		}
+		return fmt.Errorf("cannot send results to %s: %w", s.Address, err)
+	}
+	return nil
+}
+
+// open starts a stream that lives until it breaks, waiting up to the timeout for the agent.
+func (s *GRPCSink) open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(s.Timeout, cancel)
+	defer timer.Stop()
+	client, err := s.client.Publish(ctx, grpc.WaitForReady(true))
+	if err != nil {
+		cancel()
+		return err
+	}
+	stream := &grpcStream{Collector_PublishClient: client, cancel: cancel, ended: make(chan struct{})}
+	go func() {
+		// The agent only responds when ending the stream, so this returns when it does.
+		stream.err = client.RecvMsg(new(speedtesterpb.Ack))
+		close(stream.ended)
+	}()
+	s.stream = stream
+	return nil
+}
+
+// reset discards the stream, returning the error that broke it, if any.
+func (s *GRPCSink) reset() error {
+	// Closing the sending side lets the agent end the stream, unless it already did.
+	s.stream.CloseSend()
+	select {
+	case <-s.stream.ended:
+	case <-time.After(s.Timeout):
+	}
+	s.stream.cancel()
+	<-s.stream.ended
+	err := s.stream.err
+	s.stream = nil
+	return err
+}
+
+// grpcResult converts the results of a run into the message of the Collector service.
+func grpcResult(stats *Stats, now time.Time) *speedtesterpb.Result {
+	result := &speedtesterpb.Result{
+		RunId:       stats.RunID,
+		Time:        timestamppb.New(now),
+		Isp:         stats.ISP,
+		Method:      stats.Method,
+		PacketLoss:  stats.PacketLoss,
+		Labels:      stats.Labels(),
+		Annotations: stats.Annotations,
+		Download:    grpcBandwidth(stats.Download),
+		Upload:      grpcBandwidth(stats.Upload),
+	}
+	if s := stats.Server; s != nil {
+		result.Server = &speedtesterpb.Server{
+			Id:       int32(s.ID),
+			Host:     s.Host,
+			Port:     int32(s.Port),
+			Name:     s.Name,
+			Location: s.Location,
+			Country:  s.Country,
+			Ip:       s.IP,
+		}
+	}
+	if p := stats.Ping; p != nil {
+		result.Ping = &speedtesterpb.Ping{Latency: p.Latency, Jitter: p.Jitter, Low: p.Low, High: p.High}
+	}
+	return result
+}
+
+func grpcBandwidth(b *BandwidthStats) *speedtesterpb.Bandwidth {
+	if b == nil {
+		return nil
+	}
+	bandwidth := &speedtesterpb.Bandwidth{
+		Mbps:      b.GetBandWithInMbps(),
+		Bytes:     b.Bytes,
+		ElapsedMs: b.Elapsed,
+	}
+	if l := b.Latency; l != nil {
+		bandwidth.Latency = &speedtesterpb.Latency{Iqm: l.IQM, Low: l.Low, High: l.High, Jitter: l.Jitter}
+	}
+	return bandwidth
+}
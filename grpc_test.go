@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agalue/speedtester/speedtesterpb"
+	"google.golang.org/grpc"
+)
+
+// fakeCollector is an in-process agent implementing the Collector service, which
+// ends each stream after receiving the given number of results (0 for no limit).
+type fakeCollector struct {
+	speedtesterpb.UnimplementedCollectorServer
+	limit int
+
+	mu      sync.Mutex
+	results []*speedtesterpb.Result
+	streams int
+	ended   chan struct{} // Receives when a stream is ended by the agent
+}
+
+func startCollector(t *testing.T, limit int) (*fakeCollector, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeCollector{limit: limit, ended: make(chan struct{}, 10)}
+	server := grpc.NewServer()
+	speedtesterpb.RegisterCollectorServer(server, c)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+	return c, ln.Addr().String()
+}
+
+func (c *fakeCollector) Publish(stream grpc.ClientStreamingServer[speedtesterpb.Result, speedtesterpb.Ack]) error {
+	c.mu.Lock()
+	c.streams++
+	c.mu.Unlock()
+	var received int64
+	for c.limit == 0 || received < int64(c.limit) {
+		result, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		received++
+		c.mu.Lock()
+		c.results = append(c.results, result)
+		c.mu.Unlock()
+	}
+	defer func() { c.ended <- struct{}{} }()
+	return stream.SendAndClose(&speedtesterpb.Ack{Received: received})
+}
+
+// Results returns the received results, and the number of streams they came through.
+func (c *fakeCollector) Results() ([]*speedtesterpb.Result, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*speedtesterpb.Result(nil), c.results...), c.streams
+}
+
+// waitForResults waits until the agent received the given number of results.
+func (c *fakeCollector) waitForResults(t *testing.T, n int) []*speedtesterpb.Result {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, _ := c.Results()
+		if len(results) >= n {
+			return results
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d results, want %d", len(results), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGRPCResult(t *testing.T) {
+	stats := loadResult(t, "result.json")
+	stats.RunID = "run-1"
+	stats.Method = MethodOokla
+	stats.Annotations = map[string]string{"reason": "manual"}
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	result := grpcResult(stats, now)
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"run ID", result.GetRunId(), "run-1"},
+		{"time", result.GetTime().AsTime(), now},
+		{"isp", result.GetIsp(), "Acme"},
+		{"method", result.GetMethod(), MethodOokla},
+		{"server ID", result.GetServer().GetId(), int32(14774)},
+		{"server name", result.GetServer().GetName(), "UNC Chapel Hill"},
+		{"ping", result.GetPing().GetLatency(), 10.5},
+		{"download", result.GetDownload().GetMbps(), 100.0},
+		{"download bytes", result.GetDownload().GetBytes(), int64(150000000)},
+		{"download latency", result.GetDownload().GetLatency().GetIqm(), 20.1},
+		{"upload", result.GetUpload().GetMbps(), 20.0},
+		{"server_id label", result.GetLabels()["server_id"], "14774"},
+		{"annotation", result.GetAnnotations()["reason"], "manual"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if partial := grpcResult(&Stats{Method: MethodTCPPing, Ping: &PingStats{Latency: 3}}, now); partial.Download != nil || partial.Upload != nil || partial.Server != nil {
+		t.Errorf("got %v for results without bandwidth or server details", partial)
+	}
+}
+
+func TestGRPCSink(t *testing.T) {
+	collector, address := startCollector(t, 0)
+	sink, err := NewGRPCSink(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := loadResult(t, "result.json")
+	for _, id := range []string{"run-1", "run-2", "run-3"} {
+		stats.RunID = id
+		if err := sink.Publish(stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results := collector.waitForResults(t, 3)
+	for i, id := range []string{"run-1", "run-2", "run-3"} {
+		if got := results[i].GetRunId(); got != id {
+			t.Errorf("result %d has run ID %q, want %q", i, got, id)
+		}
+	}
+	if _, streams := collector.Results(); streams != 1 {
+		t.Errorf("the results came through %d streams, want 1", streams)
+	}
+}
+
+func TestGRPCSinkReopen(t *testing.T) {
+	collector, address := startCollector(t, 1)
+	sink, err := NewGRPCSink(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := loadResult(t, "result.json")
+	stats.RunID = "run-1"
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	// Once the agent ends the stream, the next publish opens a new one.
+	<-collector.ended
+	<-sink.(*GRPCSink).stream.ended
+	stats.RunID = "run-2"
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	results := collector.waitForResults(t, 2)
+	if got := results[1].GetRunId(); got != "run-2" {
+		t.Errorf("got run ID %q after reopening the stream, want run-2", got)
+	}
+	if _, streams := collector.Results(); streams != 2 {
+		t.Errorf("the results came through %d streams, want 2", streams)
+	}
+}
+
+func TestGRPCSinkUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	sink, err := NewGRPCSink(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.(*GRPCSink).Timeout = 100 * time.Millisecond
+	if err := sink.Publish(loadResult(t, "result.json")); err == nil {
+		t.Error("got no error without an agent")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Maximum duration of a hook when the test has no timeout.
+const hookTimeout = time.Minute
+
+// hookEnv returns the environment variables passed to the hooks.
+func hookEnv(limitMbps float64, iface string) []string {
+	env := []string{"SPEEDTEST_INTERFACE=" + iface}
+	if limitMbps > 0 {
+		env = append(env, "SPEEDTEST_LIMIT_MBPS="+strconv.FormatFloat(limitMbps, 'f', -1, 64))
+	}
+	return env
+}
+
+// hookCommand builds the command to execute a hook via the shell.
+func hookCommand(ctx context.Context, hook string, env []string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// runHook executes a hook, returning its output as part of the error when it fails.
+func runHook(ctx context.Context, hook string, env []string) error {
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	if out, err := hookCommand(ctx, hook, env).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
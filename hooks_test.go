@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHookEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		limitMbps float64
+		iface     string
+		want      []string
+	}{
+		{"no limit", 0, "", []string{"SPEEDTEST_INTERFACE="}},
+		{"limit", 50, "eth0", []string{"SPEEDTEST_INTERFACE=eth0", "SPEEDTEST_LIMIT_MBPS=50"}},
+		{"fractional limit", 12.5, "192.168.1.2", []string{"SPEEDTEST_INTERFACE=192.168.1.2", "SPEEDTEST_LIMIT_MBPS=12.5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hookEnv(tt.limitMbps, tt.iface); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookCommand(t *testing.T) {
+	cmd := hookCommand(context.Background(), "tc qdisc del dev eth0 root", []string{"SPEEDTEST_LIMIT_MBPS=50"})
+	if want := []string{"/bin/sh", "-c", "tc qdisc del dev eth0 root"}; !slices.Equal(cmd.Args, want) {
+		t.Errorf("got the arguments %q, want %q", cmd.Args, want)
+	}
+	if !slices.Contains(cmd.Env, "SPEEDTEST_LIMIT_MBPS=50") || len(cmd.Env) <= 1 {
+		t.Errorf("got the environment %q, want the variables of the tool on top of the inherited ones", cmd.Env)
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks require a shell")
+	}
+	if err := runHook(context.Background(), `[ "$SPEEDTEST_LIMIT_MBPS" = 50 ]`, []string{"SPEEDTEST_LIMIT_MBPS=50"}); err != nil {
+		t.Errorf("got error %v", err)
+	}
+	err := runHook(context.Background(), "echo 'tc: not found' >&2; exit 127", nil)
+	if err == nil || !strings.Contains(err.Error(), "tc: not found") {
+		t.Errorf("got error %v, want it to include the output", err)
+	}
+}
+
+func TestExecuteHooks(t *testing.T) {
+	dir := t.TempDir()
+	trace := filepath.Join(dir, "trace")
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		pre     string
+		want    []string // Expected lines on the trace, in order
+		wantErr error
+	}{
+		{
+			name: "both hooks",
+			pre:  `echo "pre $SPEEDTEST_LIMIT_MBPS $SPEEDTEST_INTERFACE" >> '` + trace + `'`,
+			want: []string{"pre 50 eth0", "cli", "post 50 eth0"},
+		},
+		{
+			name:    "failed pre-hook",
+			pre:     `echo "pre" >> '` + trace + `'; exit 1`,
+			want:    []string{"pre", "post 50 eth0"},
+			wantErr: ErrExec,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(trace)
+			tester := &SpeedTester{
+				Command:   fakeCLI(t, `echo cli >> '`+trace+`'; cat '`+fixture+`'`),
+				LimitMbps: 50,
+				PreHook:   tt.pre,
+				PostHook:  `echo "post $SPEEDTEST_LIMIT_MBPS $SPEEDTEST_INTERFACE" >> '` + trace + `'`,
+			}
+			tester.Metrics()
+			if _, err := tester.execute(testLogger(t), 0, "eth0"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			data, err := os.ReadFile(trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitRequiresPreHook(t *testing.T) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	options := newTesterOptions(fs)
+	if err := fs.Parse([]string{"-limit-mbps=50"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := options.build(); err == nil || !strings.Contains(err.Error(), "--pre-hook") {
+		t.Errorf("got error %v, want the pre-hook to be required", err)
+	}
+}
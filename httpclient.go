@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=...", falling back
+// to the version of the module when installed with go install.
+var version = "dev"
+
+// instanceID identifies this instance on the requests of the sinks, via --instance-id.
+var instanceID string
+
+// currentVersion returns the version of the running exporter.
+func currentVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
+
+// userAgent returns the User-Agent of the requests of the sinks, e.g. speedtester/1.2.0
+func userAgent() string {
+	return "speedtester/" + currentVersion()
+}
+
+// identifyingTransport adds the User-Agent and the instance ID to the requests, so
+// the receivers can attribute the data.
+type identifyingTransport struct {
+	base http.RoundTripper
+}
+
+func (t identifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent())
+	if instanceID != "" {
+		req.Header.Set("X-Instance-ID", instanceID)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newHTTPClient returns the client shared by the sinks and the alert webhook.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: identifyingTransport{base: http.DefaultTransport}}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestIdentifyingHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		instanceID string
+		send       func(t *testing.T, url string) // Sends a request to the receiver at url
+	}{
+		{name: "client", send: clientRequest},
+		{name: "client with an instance ID", instanceID: "router-1", send: clientRequest},
+		{name: "remote-write sink", instanceID: "router-1", send: remoteWriteRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := instanceID
+			instanceID = tt.instanceID
+			t.Cleanup(func() { instanceID = previous })
+			headers := make(chan http.Header, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers <- r.Header.Clone()
+			}))
+			t.Cleanup(server.Close)
+
+			tt.send(t, server.URL)
+			header := <-headers
+			if got, want := header.Get("User-Agent"), "speedtester/"+currentVersion(); got != want {
+				t.Errorf("User-Agent = %q, want %q", got, want)
+			}
+			if got, ok := header["X-Instance-Id"]; ok != (tt.instanceID != "") || ok && got[0] != tt.instanceID {
+				t.Errorf("X-Instance-ID = %q, want %q", got, tt.instanceID)
+			}
+		})
+	}
+}
+
+func clientRequest(t *testing.T, url string) {
+	t.Helper()
+	resp, err := newHTTPClient(0).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func remoteWriteRequest(t *testing.T, url string) {
+	t.Helper()
+	sink, err := NewRemoteWriteSink(url, prometheus.NewRegistry(), nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Publish(loadResult(t, "result.json")); err != nil {
+		t.Fatal(err)
+	}
+}
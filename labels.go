@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels every gauge already has, which cannot be overridden.
+var reservedLabels = []string{"isp", "server_id", "server_name", "server_location", "interface", "method", "latency"}
+
+// ExtraLabel maps a field of the parsed result into an additional Prometheus label.
+// The field is referenced by its JSON path, for instance "server.country".
+type ExtraLabel struct {
+	Field string
+	Label string
+	path  []int // Indexes of the struct fields to traverse from Stats
+}
+
+// Value returns the content of the referenced field from the given stats,
+// or an empty string when any of the traversed objects is missing.
+func (l ExtraLabel) Value(stats *Stats) string {
+	v := reflect.ValueOf(stats)
+	for _, i := range l.path {
+		if v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return ""
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// ParseExtraLabels parses a list of field=label entries, validating that the
+// fields exist on the parsed result and that the label names are valid.
+func ParseExtraLabels(entries []string) ([]ExtraLabel, error) {
+	var labels []ExtraLabel
+	for _, entry := range entries {
+		field, label, ok := strings.Cut(entry, "=")
+		if !ok || field == "" || label == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected field=label", entry)
+		}
+		if !labelNameRegex.MatchString(label) || strings.HasPrefix(label, "__") {
+			return nil, fmt.Errorf("invalid label name %q", label)
+		}
+		if slices.Contains(reservedLabels, label) {
+			return nil, fmt.Errorf("label name %q is reserved", label)
+		}
+		if slices.ContainsFunc(labels, func(l ExtraLabel) bool { return l.Label == label }) {
+			return nil, fmt.Errorf("duplicate label name %q", label)
+		}
+		path, err := fieldPath(reflect.TypeOf(Stats{}), field)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, ExtraLabel{Field: field, Label: label, path: path})
+	}
+	return labels, nil
+}
+
+// fieldPath resolves a dotted JSON path into the indexes of the struct fields to traverse.
+// The referenced field must be a scalar value.
+func fieldPath(t reflect.Type, field string) ([]int, error) {
+	var path []int
+	for _, name := range strings.Split(field, ".") {
+		if t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("field %q not found", field)
+		}
+		index := -1
+		for i := range t.NumField() {
+			tag, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if tag != "" && tag != "-" && tag == name {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("field %q not found", field)
+		}
+		path = append(path, index)
+		t = t.Field(index).Type
+	}
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Slice, reflect.Map, reflect.Array, reflect.Interface:
+		return nil, fmt.Errorf("field %q is not a scalar value", field)
+	}
+	return path, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtraLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "valid", entries: []string{"server.country=country", "interface.isVpn=vpn", "download.bandwidth=_bandwidth"}},
+		{name: "missing label", entries: []string{"server.country"}, wantErr: "expected field=label"},
+		{name: "empty field", entries: []string{"=country"}, wantErr: "expected field=label"},
+		{name: "invalid label name", entries: []string{"server.country=1country"}, wantErr: `invalid label name "1country"`},
+		{name: "dashes in the label name", entries: []string{"server.country=server-country"}, wantErr: "invalid label name"},
+		{name: "internal label name", entries: []string{"server.country=__country"}, wantErr: "invalid label name"},
+		{name: "reserved label name", entries: []string{"server.country=isp"}, wantErr: `label name "isp" is reserved`},
+		{name: "duplicate label name", entries: []string{"server.country=where", "server.location=where"}, wantErr: `duplicate label name "where"`},
+		{name: "unknown field", entries: []string{"server.continent=continent"}, wantErr: `field "server.continent" not found`},
+		{name: "field of a scalar", entries: []string{"isp.name=isp_name"}, wantErr: "not found"},
+		{name: "Go field name", entries: []string{"Server.Country=country"}, wantErr: "not found"},
+		{name: "object", entries: []string{"server=server"}, wantErr: "is not a scalar value"},
+		{name: "array", entries: []string{"ping.samples=samples"}, wantErr: "is not a scalar value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseExtraLabels(tt.entries)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtraLabelValue(t *testing.T) {
+	tests := []struct {
+		field string
+		stats func(*Stats) // Changes the fixture before extracting the value
+		want  string
+	}{
+		{field: "server.country", want: "United States"},
+		{field: "server.port", want: "8080"},
+		{field: "interface.isVpn", want: "false"},
+		{field: "interface.externalIp", want: "1.2.3.4"},
+		{field: "download.bandwidth", want: "12500000"},
+		{field: "result.persisted", want: "true"},
+		{field: "isp", want: "Acme"},
+		{field: "interface.name", stats: func(s *Stats) { s.Interface = nil }, want: ""},
+		{field: "download.latency.iqm", stats: func(s *Stats) { s.Download.Latency = nil }, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			labels, err := ParseExtraLabels([]string{tt.field + "=label"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats := loadResult(t, "result.json")
+			if tt.stats != nil {
+				tt.stats(stats)
+			}
+			if got := labels[0].Value(stats); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
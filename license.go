@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sync/atomic"
+)
+
+// Prompts printed by the CLI when the license or the GDPR terms must be accepted interactively.
+var licensePromptRegex = regexp.MustCompile(`(?i)do you accept the license|type yes to accept`)
+
+// promptWatcher collects the output of the CLI, cancelling it as soon as it prints a
+// license prompt, since it would otherwise wait for input until the timeout.
+type promptWatcher struct {
+	buf      *bytes.Buffer
+	prompted *atomic.Bool // Shared by the watchers of stdout and stderr
+	cancel   context.CancelFunc
+}
+
+func (w *promptWatcher) Write(p []byte) (int, error) {
+	n, err := w.buf.Write(p)
+	if !w.prompted.Load() && licensePromptRegex.Match(w.buf.Bytes()) {
+		w.prompted.Store(true)
+		w.cancel()
+	}
+	return n, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPromptWatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   bool
+	}{
+		{name: "results", writes: []string{`{"type":"result"}`}},
+		{name: "license", writes: []string{"Do you accept the license? [type YES to accept]: "}, want: true},
+		{name: "GDPR", writes: []string{"==============================================================================\n\nYou may only use this Speedtest software ", "...\nType YES to accept: "}, want: true},
+		{name: "split across writes", writes: []string{"Do you accept the lic", "ense? "}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var buf bytes.Buffer
+			var prompted atomic.Bool
+			w := &promptWatcher{buf: &buf, prompted: &prompted, cancel: cancel}
+			for _, s := range tt.writes {
+				if _, err := w.Write([]byte(s)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := prompted.Load(); got != tt.want {
+				t.Errorf("prompted = %t, want %t", got, tt.want)
+			}
+			if cancelled := ctx.Err() != nil; cancelled != tt.want {
+				t.Errorf("cancelled the CLI: %t, want %t", cancelled, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteLicensePrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"on stdout", `printf 'Do you accept the license? [type YES to accept]: '; read answer; sleep 30`},
+		{"on stderr", `printf 'Type YES to accept: ' >&2; sleep 30`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{Command: fakeCLI(t, tt.script), Timeout: time.Minute}
+			tester.Metrics()
+			start := time.Now()
+			_, err := tester.execute(testLogger(t), 0, "")
+			if !errors.Is(err, ErrValidation) {
+				t.Fatalf("got error %v, want %v", err, ErrValidation)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("failed after %s, want it right after the prompt", elapsed)
+			}
+		})
+	}
+}
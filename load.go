@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadSource returns the current load of the host.
+type LoadSource func() (float64, error)
+
+// ReadLoadAvg returns the 1-minute load average from /proc/loadavg (only available on Linux).
+func ReadLoadAvg() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected content on /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// sampleLoad samples the load periodically until the returned function is called,
+// which returns the peak of the observed values.
+func sampleLoad(source LoadSource, interval time.Duration) func() (float64, error) {
+	type result struct {
+		peak float64
+		err  error
+	}
+	done := make(chan struct{})
+	results := make(chan result)
+	go func() {
+		peak, err := source()
+		sample := func() {
+			if load, e := source(); e == nil && load > peak {
+				peak = load
+			}
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sample()
+			case <-done:
+				sample()
+				results <- result{peak, err}
+				return
+			}
+		}
+	}()
+	return func() (float64, error) {
+		close(done)
+		r := <-results
+		return r.peak, r.err
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeLoad returns a load source yielding the given values in order, repeating the
+// last one, and a channel closed once all of them were returned.
+func fakeLoad(values ...float64) (LoadSource, <-chan struct{}) {
+	var mu sync.Mutex
+	done := make(chan struct{})
+	i := 0
+	return func() (float64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		v := values[min(i, len(values)-1)]
+		if i++; i == len(values) {
+			close(done)
+		}
+		return v, nil
+	}, done
+}
+
+func TestSampleLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   float64
+	}{
+		{"single sample", []float64{0.5}, 0.5},
+		{"peak in the middle", []float64{0.5, 3.25, 1}, 3.25},
+		{"peak at the end", []float64{0.5, 1, 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, sampled := fakeLoad(tt.values...)
+			stop := sampleLoad(source, time.Millisecond)
+			<-sampled
+			peak, err := stop()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if peak != tt.want {
+				t.Errorf("got a peak of %v, want %v", peak, tt.want)
+			}
+		})
+	}
+}
+
+func TestSampleLoadError(t *testing.T) {
+	unavailable := errors.New("no /proc/loadavg")
+	stop := sampleLoad(func() (float64, error) { return 0, unavailable }, time.Hour)
+	if _, err := stop(); !errors.Is(err, unavailable) {
+		t.Errorf("got error %v, want %v", err, unavailable)
+	}
+}
+
+func TestHostLoadDuringTest(t *testing.T) {
+	source, _ := fakeLoad(1.5, 6)
+	tester := &SpeedTester{Command: fixtureCLI(t, "result.json"), LoadSource: source, MaxLoad: 4}
+	metrics := tester.Metrics()
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// The sampling interval is longer than the test, so it samples at the start and the end.
+	if got := testutil.ToFloat64(metrics.HostLoad); got != 6 {
+		t.Errorf("speedtest_host_load_during_test = %v, want 6", got)
+	}
+}
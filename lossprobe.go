@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	lossProbeBurst          = 10                     // Connection attempts of each probe
+	lossProbeInterval       = 50 * time.Millisecond  // Between the attempts of a probe
+	defaultLossProbeTimeout = 500 * time.Millisecond // Shorter than the retransmission of a lost SYN
+)
+
+// ProbeLoss is the packet loss measured by the built-in probes, besides the one of the CLI.
+type ProbeLoss struct {
+	Percent float64 `json:"percent"`
+	Samples int     `json:"samples"` // Total attempts, the more, the more trustworthy the percentage
+}
+
+// probeLoss measures the packet loss with the given number of parallel probes, each a
+// small burst of TCP connection attempts, which don't require privileges unlike ICMP
+// and need no responder unlike UDP. An attempt without an answer (a SYN-ACK or a
+// reset) within the timeout is counted as lost, as a lost SYN is retransmitted later.
+func probeLoss(ctx context.Context, address, iface string, probes int, timeout time.Duration) *ProbeLoss {
+	dialer := &net.Dialer{Timeout: timeout}
+	if ip := net.ParseIP(iface); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	var mu sync.Mutex
+	var lost, samples int
+	var wg sync.WaitGroup
+	for range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range lossProbeBurst {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(lossProbeInterval):
+					}
+				}
+				conn, err := dialer.DialContext(ctx, "tcp", address)
+				if ctx.Err() != nil {
+					return // Aborted, not lost
+				}
+				mu.Lock()
+				samples++
+				if err == nil {
+					conn.Close()
+				} else if !errors.Is(err, syscall.ECONNREFUSED) {
+					lost++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if samples == 0 {
+		return nil
+	}
+	return &ProbeLoss{Percent: 100 * float64(lost) / float64(samples), Samples: samples}
+}
+
+// measureProbeLoss runs the loss probes against the server of the results, right after
+// the test so they don't compete with it for the bandwidth.
+func (t *SpeedTester) measureProbeLoss(logger *log.Logger, stats *Stats) {
+	s := stats.Server
+	if s == nil || s.Host == "" || s.Port <= 0 {
+		logger.Println("Skipping the loss probes, the address of the server is unknown")
+		return
+	}
+	address := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	timeout := (t.ProbeTimeout+lossProbeInterval)*lossProbeBurst + 5*time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stats.ProbeLoss = probeLoss(ctx, address, stats.Source, t.LossProbes, t.ProbeTimeout)
+	if stats.ProbeLoss != nil {
+		logger.Printf("Probes to %s lost %.1f%% of %d attempts", address, stats.ProbeLoss.Percent, stats.ProbeLoss.Samples)
+	}
+}
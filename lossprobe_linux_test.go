@@ -0,0 +1,65 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// droppingListener returns the address of a local listener that never accepts, with
+// room for a single pending connection, after which Linux drops the SYNs.
+func droppingListener(t *testing.T) string {
+	t.Helper()
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { syscall.Close(fd) })
+	if err := syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Listen(fd, 0); err != nil {
+		t.Fatal(err)
+	}
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(sa.(*syscall.SockaddrInet4).Port))
+}
+
+func TestProbeLossDropped(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending int // Connections filling the queue of the listener before the probes
+		want    float64
+	}{
+		// Only the first attempt fits in the queue.
+		{name: "partial loss", want: 100 * float64(2*lossProbeBurst-1) / float64(2*lossProbeBurst)},
+		{name: "full loss", pending: 1, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := droppingListener(t)
+			for range tt.pending {
+				conn, err := net.DialTimeout("tcp", address, time.Second)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { conn.Close() })
+			}
+			got := probeLoss(context.Background(), address, "", 2, 20*time.Millisecond)
+			if got == nil {
+				t.Fatal("got no results")
+			}
+			if got.Percent != tt.want || got.Samples != 2*lossProbeBurst {
+				t.Errorf("got %+v, want %v%% of %d samples", got, tt.want, 2*lossProbeBurst)
+			}
+		})
+	}
+}
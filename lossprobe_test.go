@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// closedPort returns the address of a local port without a listener, which refuses
+// the connections.
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	return address
+}
+
+func TestProbeLoss(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		probes  int
+		want    float64
+	}{
+		{name: "answered", address: pingListener(t), probes: 3, want: 0},
+		{name: "refused is not lost", address: closedPort(t), probes: 2, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := probeLoss(context.Background(), tt.address, "127.0.0.1", tt.probes, 100*time.Millisecond)
+			if got == nil {
+				t.Fatal("got no results")
+			}
+			if got.Percent != tt.want || got.Samples != tt.probes*lossProbeBurst {
+				t.Errorf("got %+v, want %v%% of %d samples", got, tt.want, tt.probes*lossProbeBurst)
+			}
+		})
+	}
+}
+
+func TestProbeLossAborted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := probeLoss(ctx, pingListener(t), "", 2, 100*time.Millisecond); got != nil {
+		t.Errorf("got %+v after the probes were aborted, want none", got)
+	}
+}
+
+func TestMeasureProbeLoss(t *testing.T) {
+	host, port, err := net.SplitHostPort(pingListener(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(port)
+	tests := []struct {
+		name   string
+		server *ServerInfo
+		want   bool // Whether the probes run
+	}{
+		{name: "server", server: &ServerInfo{Host: host, Port: n}, want: true},
+		{name: "unknown port", server: &ServerInfo{Host: host}},
+		{name: "unknown server"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{LossProbes: 1, ProbeTimeout: 100 * time.Millisecond}
+			stats := &Stats{Server: tt.server}
+			tester.measureProbeLoss(testLogger(t), stats)
+			if got := stats.ProbeLoss != nil; got != tt.want {
+				t.Fatalf("got the probe loss %+v, want it measured: %t", stats.ProbeLoss, tt.want)
+			}
+			if tt.want && (stats.ProbeLoss.Percent != 0 || stats.ProbeLoss.Samples != lossProbeBurst) {
+				t.Errorf("got %+v, want no loss over %d samples", stats.ProbeLoss, lossProbeBurst)
+			}
+		})
+	}
+}
+
+func TestProbeLossSeries(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int // Attempts of each run, on the same server
+		want    string
+	}{
+		{name: "same attempts", samples: []int{30, 30}, want: "30"},
+		{name: "fewer attempts", samples: []int{30, 12}, want: "12"},
+		{name: "more attempts", samples: []int{12, 30}, want: "30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &PrometheusStats{}
+			s.Init()
+			stats := loadResult(t, "result.json")
+			for _, n := range tt.samples {
+				stats.ProbeLoss = &ProbeLoss{Percent: 1, Samples: n}
+				s.Update(stats)
+			}
+			if got := labelValues(t, s, "speedtest_probe_packet_loss", "samples"); !slices.Equal(got, []string{tt.want}) {
+				t.Errorf("speedtest_probe_packet_loss has series for samples %q, want only %q", got, tt.want)
+			}
+		})
+	}
+}
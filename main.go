@@ -3,63 +3,127 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"net/http"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
-	"os/signal"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 type LatencyStats struct {
-	IQM    float64 `json:"iqm"`
-	Low    float64 `json:"low"`
-	High   float64 `json:"high"`
-	Jitter float64 `json:"jitter"`
+	IQM     float64        `json:"iqm"`
+	Low     float64        `json:"low"`
+	High    float64        `json:"high"`
+	Jitter  float64        `json:"jitter"`
+	Samples LatencySamples `json:"samples,omitempty"` // Only reported by some CLI versions
 }
 
+// BandwidthStats holds the results of the download or upload test. The counters are
+// int64 so multi-gigabit results don't overflow on 32-bit hardware (e.g. routers).
 type BandwidthStats struct {
-	Bandwidth int           `json:"bandwidth"`
-	Bytes     int           `json:"bytes"`
-	Elapsed   int           `json:"elapsed"`
+	Bandwidth int64         `json:"bandwidth"` // Bytes per second
+	Bytes     int64         `json:"bytes"`
+	Elapsed   int64         `json:"elapsed"` // Milliseconds
 	Latency   *LatencyStats `json:"latency"`
 }
 
+// GetBandWithInMbps converts the bandwidth in bytes per second to megabits per second,
+// in floating point so it cannot overflow.
 func (s *BandwidthStats) GetBandWithInMbps() float64 {
-	return float64(s.Bandwidth) * 0.000008
+	return float64(s.Bandwidth) * 8 / 1e6
+}
+
+func (s *BandwidthStats) String() string {
+	if s.Latency == nil {
+		return fmt.Sprintf("%.2f Mbps", s.GetBandWithInMbps())
+	}
+	return fmt.Sprintf("%.2f Mbps (latency: %.2f/%.2f ms, jitter: %.2f ms)", s.GetBandWithInMbps(), s.Latency.IQM, s.Latency.High, s.Latency.Jitter)
 }
 
 type PingStats struct {
-	Jitter  float64 `json:"jitter"`
-	Latency float64 `json:"latency"`
-	Low     float64 `json:"low"`
-	High    float64 `json:"high"`
+	Jitter  float64        `json:"jitter"`
+	Latency float64        `json:"latency"`
+	Low     float64        `json:"low"`
+	High    float64        `json:"high"`
+	Samples LatencySamples `json:"samples,omitempty"` // Only reported by some CLI versions
 }
 
 type ServerInfo struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	Location string `json:"location"`
+	ID       int     `json:"id"`
+	Host     string  `json:"host"`
+	Port     int     `json:"port"`
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	Country  string  `json:"country"`
+	IP       string  `json:"ip"`
+	Distance float64 `json:"distance,omitempty"` // In km, when reported by the CLI
+}
+
+type InterfaceInfo struct {
+	InternalIP string `json:"internalIp"`
+	Name       string `json:"name"`
+	MacAddr    string `json:"macAddr"`
+	IsVPN      bool   `json:"isVpn"`
+	ExternalIP string `json:"externalIp"`
+}
+
+type ResultInfo struct {
+	ID        string `json:"id"`
+	URL       string `json:"url"`
+	Persisted bool   `json:"persisted"`
 }
 
 func (s *ServerInfo) GetID() string {
 	return strconv.Itoa(s.ID)
 }
 
+// FartherThan returns whether the server is known to be farther than the given
+// distance in km, which is false when the distance is not reported by the CLI.
+func (s *ServerInfo) FartherThan(km float64) bool {
+	return km > 0 && s.Distance > km
+}
+
+// Methods used to measure the results, exposed via the method label so results
+// from incomparable sources are not mixed on the dashboards.
+const (
+	MethodOokla     = "ookla"         // The speedtest CLI
+	MethodFallback  = "http-fallback" // The built-in HTTP download test
+	MethodSimulated = "simulated"     // Synthetic results generated with --simulate
+	MethodTCPPing   = "tcp-ping"      // The built-in pinger, when a test is skipped by --max-ping-ms
+)
+
 type Stats struct {
-	Server     *ServerInfo     `json:"server"`
-	Ping       *PingStats      `json:"ping"`
-	Download   *BandwidthStats `json:"download"`
-	Upload     *BandwidthStats `json:"upload"`
-	PacketLoss float64         `json:"packetLoss"`
-	ISP        string          `json:"isp"`
+	Server      *ServerInfo       `json:"server"`
+	Ping        *PingStats        `json:"ping"`
+	Download    *BandwidthStats   `json:"download"`
+	Upload      *BandwidthStats   `json:"upload"`
+	PacketLoss  float64           `json:"packetLoss"`
+	ISP         string            `json:"isp"`
+	Interface   *InterfaceInfo    `json:"interface"`
+	Result      *ResultInfo       `json:"result"`
+	Source      string            `json:"-"` // Interface or source IP the test was bound to (if any)
+	RunID       string            `json:"runId,omitempty"`
+	Method      string            `json:"method,omitempty"`
+	CacheHit    bool              `json:"cacheHit,omitempty"`    // Whether the HTTP fallback was served from a cache
+	CLIDuration time.Duration     `json:"-"`                     // Time the CLI took to run the test
+	Timestamp   time.Time         `json:"timestamp"`             // When the test was measured, as reported by the CLI or when published
+	ProbeLoss   *ProbeLoss        `json:"probeLoss,omitempty"`   // Measured by the built-in loss probes, when enabled
+	Annotations map[string]string `json:"annotations,omitempty"` // Context provided when triggering the run on demand
 }
 
 func (s *Stats) HasError() error {
@@ -84,77 +148,496 @@ func (s *Stats) HasError() error {
 	return nil
 }
 
-func (s *Stats) Log() {
-	if s.HasError() != nil {
+func (s *Stats) Log(logger *log.Logger) {
+	if s.Server == nil {
 		return
 	}
-	log.Printf("Server %d: %s (ISP: %s)", s.Server.ID, s.Server.Name, s.ISP)
-	log.Printf("Download %.2f Mbps (latency: %.2f/%.2f ms, jitter: %.2f ms)", s.Download.GetBandWithInMbps(), s.Download.Latency.IQM, s.Download.Latency.High, s.Download.Latency.Jitter)
-	log.Printf("Upload %.2f Mbps (latency: %.2f/%.2f ms, jitter: %.2f ms)", s.Upload.GetBandWithInMbps(), s.Upload.Latency.IQM, s.Upload.Latency.High, s.Upload.Latency.Jitter)
-	log.Printf("Ping %.2f/%.2f ms (jitter: %.2f ms)", s.Ping.Latency, s.Ping.High, s.Ping.Jitter)
+	if s.Source != "" {
+		logger.Printf("Server %d: %s (ISP: %s, interface: %s, method: %s)", s.Server.ID, s.Server.Name, s.ISP, s.Source, s.Method)
+	} else {
+		logger.Printf("Server %d: %s (ISP: %s, method: %s)", s.Server.ID, s.Server.Name, s.ISP, s.Method)
+	}
+	if s.Download != nil {
+		logger.Printf("Download %s", s.Download)
+	}
+	if s.Upload != nil {
+		logger.Printf("Upload %s", s.Upload)
+	}
+	if s.Ping != nil {
+		logger.Printf("Ping %.2f/%.2f ms (jitter: %.2f ms)", s.Ping.Latency, s.Ping.High, s.Ping.Jitter)
+	}
+}
+
+// HasPacketLoss returns whether the method measures the packet loss (the HTTP fallback doesn't).
+func (s *Stats) HasPacketLoss() bool {
+	return s.Method == MethodOokla || s.Method == MethodSimulated
+}
+
+// Labels returns the labels that describe the server and the ISP of the results.
+func (s *Stats) Labels() map[string]string {
+	labels := map[string]string{"isp": s.ISP, "method": s.Method}
+	if s.Server != nil {
+		labels["server_id"] = s.Server.GetID()
+		labels["server_name"] = s.Server.Name
+		labels["server_location"] = s.Server.Location
+	}
+	if s.Source != "" {
+		labels["interface"] = s.Source
+	}
+	return labels
+}
+
+// PhaseDurations returns the duration in ms of the phases of the test reported by
+// the results. The CLI only reports those of the download and upload phases, so the
+// ping phase is the rest of the time the CLI took, including the server selection.
+func (s *Stats) PhaseDurations() map[string]int64 {
+	phases := make(map[string]int64)
+	var elapsed int64
+	if s.Download != nil && s.Download.Elapsed > 0 {
+		phases["download"] = s.Download.Elapsed
+		elapsed += s.Download.Elapsed
+	}
+	if s.Upload != nil && s.Upload.Elapsed > 0 {
+		phases["upload"] = s.Upload.Elapsed
+		elapsed += s.Upload.Elapsed
+	}
+	if rest := s.CLIDuration.Milliseconds() - elapsed; s.CLIDuration > 0 && rest >= 0 {
+		phases["ping"] = rest
+	}
+	return phases
+}
+
+// ToMap returns the available values of the results indexed by metric name, with units as suffix.
+func (s *Stats) ToMap() map[string]float64 {
+	values := make(map[string]float64)
+	if s.Download != nil {
+		values["download_mbps"] = s.Download.GetBandWithInMbps()
+		if s.Download.Latency != nil {
+			values["download_latency_ms"] = s.Download.Latency.IQM
+			values["download_jitter_ms"] = s.Download.Latency.Jitter
+		}
+	}
+	if s.Upload != nil {
+		values["upload_mbps"] = s.Upload.GetBandWithInMbps()
+		if s.Upload.Latency != nil {
+			values["upload_latency_ms"] = s.Upload.Latency.IQM
+			values["upload_jitter_ms"] = s.Upload.Latency.Jitter
+		}
+	}
+	if s.Ping != nil {
+		values["ping_latency_ms"] = s.Ping.Latency
+		values["ping_jitter_ms"] = s.Ping.Jitter
+	}
+	if s.HasPacketLoss() {
+		values["packet_loss_percent"] = s.PacketLoss
+	}
+	if s.ProbeLoss != nil {
+		values["probe_packet_loss_percent"] = s.ProbeLoss.Percent
+	}
+	return values
+}
+
+// Summary returns a single-line description of the available results.
+func (s *Stats) Summary() string {
+	if s.Server == nil {
+		return ""
+	}
+	summary := fmt.Sprintf("run=%s method=%s server=%d isp=%q", s.RunID, s.Method, s.Server.ID, s.ISP)
+	if s.Download != nil {
+		summary += fmt.Sprintf(" download=%.2fMbps", s.Download.GetBandWithInMbps())
+	}
+	if s.Upload != nil {
+		summary += fmt.Sprintf(" upload=%.2fMbps", s.Upload.GetBandWithInMbps())
+	}
+	if s.Ping != nil {
+		summary += fmt.Sprintf(" ping=%.2fms jitter=%.2fms", s.Ping.Latency, s.Ping.Jitter)
+	}
+	if s.HasPacketLoss() {
+		summary += fmt.Sprintf(" loss=%.2f%%", s.PacketLoss)
+	}
+	return summary
 }
 
 type PrometheusStats struct {
 	DownloadBandwidth *prometheus.GaugeVec
 	DownloadLatency   *prometheus.GaugeVec
 	DownloadJitter    *prometheus.GaugeVec
+	DownloadMin       *prometheus.GaugeVec // Lowest download rate since the start or the last reset
+	DownloadMax       *prometheus.GaugeVec // Highest download rate since the start or the last reset
+	DownloadEWMA      *prometheus.GaugeVec
+	UploadEWMA        *prometheus.GaugeVec
+	PingEWMA          *prometheus.GaugeVec
 	UploadBandwidth   *prometheus.GaugeVec
 	UploadLatency     *prometheus.GaugeVec
 	UploadJitter      *prometheus.GaugeVec
 	PingLatency       *prometheus.GaugeVec
 	PingJitter        *prometheus.GaugeVec
 	PacketLoss        *prometheus.GaugeVec
+	PacketLossDist    *prometheus.HistogramVec // Distribution of the packet loss over the runs
+	ProbeLoss         *prometheus.GaugeVec
+	ServerFar         *prometheus.GaugeVec
+	Retries           prometheus.Gauge
+	AnchorLatency     *prometheus.GaugeVec
+	CLIDuration       *prometheus.GaugeVec
+	CLIOutputBytes    prometheus.Gauge
+	PhaseDuration     *prometheus.GaugeVec
 	Requests          *prometheus.CounterVec
+	FailureReasons    *prometheus.CounterVec
+	Failures          prometheus.Gauge
+	Successes         prometheus.Gauge
+	ConfigInfo        *prometheus.GaugeVec
+	HostLoad          prometheus.Gauge
+	SinkErrors        *prometheus.CounterVec
+	ServersSkipped    *prometheus.CounterVec
+	BinaryMtime       prometheus.Gauge
+	NextRun           prometheus.Gauge
+	UpdateAvailable   *prometheus.GaugeVec
+	FallbackCacheHit  prometheus.Gauge
+	Paused            prometheus.Gauge
+	ExtraLabels       []ExtraLabel
+	MetricStyle       string          // split (default) or combined
+	MaxDistanceKm     float64         // When positive, ServerFar is set for the servers with a known distance
+	DisabledMetrics   map[string]bool // Optional families of metrics that are not registered
+	RunTimestamps     bool            // Whether the result series carry the time of the run instead of the scrape
+	Registry          *prometheus.Registry
+	snapshotMu        sync.RWMutex         // Held by the scrapes as readers, so they never see a run half-published
+	labelNames        []string             // Labels shared by the result gauges
+	resultTimes       map[string]time.Time // Timestamps of the published results by their label values, protected by snapshotMu
+
+	extremesMu sync.Mutex // Protects extremes, updated by the runs and reset via HTTP
+	extremes   map[string]extremes
 }
 
 func (s *PrometheusStats) Init() {
+	labels := []string{"isp", "server_id", "server_name", "server_location", "interface", "method"}
+	for _, l := range s.ExtraLabels {
+		labels = append(labels, l.Label)
+	}
+	latencyLabels := append(slices.Clone(labels), "latency")
+	s.labelNames = labels
+
 	s.Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "speedtest_total_requests",
 		Help: "The total number of requests",
 	}, []string{"status"})
+	s.FailureReasons = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedtest_failures_total",
+		Help: "The total number of failed requests by reason",
+	}, []string{"reason"})
+	s.Failures = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_consecutive_failures",
+		Help: "The number of consecutive failed requests",
+	})
+	s.Successes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_consecutive_successes",
+		Help: "The number of consecutive successful requests",
+	})
+	s.ConfigInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_config_info",
+		Help: "Information about the active configuration (always 1)",
+	}, []string{"cli_options", "limit_mbps", "proxy"})
+	s.ServersSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedtest_servers_skipped_total",
+		Help: "The number of times a configured server failed and was skipped in favor of the next one",
+	}, []string{"server_id"})
+	s.SinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedtest_sink_errors_total",
+		Help: "The total number of errors publishing results to the sinks",
+	}, []string{"sink"})
+	s.Paused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_paused",
+		Help: "Whether the scheduled runs are paused by a maintenance window",
+	})
+	s.BinaryMtime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_binary_mtime_seconds",
+		Help: "The modification time of the speedtest CLI binary in seconds since epoch",
+	})
+	s.NextRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_next_run_timestamp_seconds",
+		Help: "The expected time of the next scheduled run in seconds since epoch",
+	})
+	s.UpdateAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_exporter_update_available",
+		Help: "Whether a newer release of the exporter is available (1) or not (0), with the latest version as a label",
+	}, []string{"latest_version"})
+	s.FallbackCacheHit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_fallback_cache_hit",
+		Help: "Whether the last HTTP fallback download was served from a cache (1) or not (0)",
+	})
+	s.HostLoad = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_host_load_during_test",
+		Help: "The peak load average of the host while running the last test",
+	})
 
-	s.DownloadBandwidth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_download_speed",
-		Help: "The Download Rate in Mbps",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
-	s.DownloadLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_download_latency",
-		Help: "The Download Latency in milliseconds (iqm, low, high)",
-	}, []string{"isp", "server_id", "server_name", "server_location", "latency"})
-	s.DownloadJitter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_download_jitter",
-		Help: "The Download Jitter in milliseconds",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
-
-	s.UploadBandwidth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_upload_speed",
-		Help: "The Upload Rate in Mbps",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
-	s.UploadLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_upload_latency",
-		Help: "The Upload Latency in milliseconds (iqm, low, high)",
-	}, []string{"isp", "server_id", "server_name", "server_location", "latency"})
-	s.UploadJitter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "speedtest_upload_jitter",
-		Help: "The Upload Jitter in milliseconds",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
+	var bandwidthVecs, latencyVecs, jitterVecs []prometheus.Collector
+	if s.MetricStyle == MetricStyleCombined {
+		// The download and upload gauges are views of a single metric with a direction label.
+		bandwidth := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_bandwidth_mbps",
+			Help: "The Download or Upload Rate in Mbps",
+		}, append(slices.Clone(labels), "direction"))
+		latency := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_bandwidth_latency",
+			Help: "The Download or Upload Latency in milliseconds (iqm, low, high, and p50, p90, p99 when the CLI reports the samples)",
+		}, append(slices.Clone(latencyLabels), "direction"))
+		jitter := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_bandwidth_jitter",
+			Help: "The Download or Upload Jitter in milliseconds",
+		}, append(slices.Clone(labels), "direction"))
+		download := prometheus.Labels{"direction": "download"}
+		upload := prometheus.Labels{"direction": "upload"}
+		s.DownloadBandwidth = bandwidth.MustCurryWith(download)
+		s.DownloadLatency = latency.MustCurryWith(download)
+		s.DownloadJitter = jitter.MustCurryWith(download)
+		s.UploadBandwidth = bandwidth.MustCurryWith(upload)
+		s.UploadLatency = latency.MustCurryWith(upload)
+		s.UploadJitter = jitter.MustCurryWith(upload)
+		bandwidthVecs = []prometheus.Collector{bandwidth}
+		latencyVecs = []prometheus.Collector{latency}
+		jitterVecs = []prometheus.Collector{jitter}
+	} else {
+		s.DownloadBandwidth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_download_speed",
+			Help: "The Download Rate in Mbps",
+		}, labels)
+		s.DownloadLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_download_latency",
+			Help: "The Download Latency in milliseconds (iqm, low, high, and p50, p90, p99 when the CLI reports the samples)",
+		}, latencyLabels)
+		s.DownloadJitter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_download_jitter",
+			Help: "The Download Jitter in milliseconds",
+		}, labels)
+		s.UploadBandwidth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_upload_speed",
+			Help: "The Upload Rate in Mbps",
+		}, labels)
+		s.UploadLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_upload_latency",
+			Help: "The Upload Latency in milliseconds (iqm, low, high, and p50, p90, p99 when the CLI reports the samples)",
+		}, latencyLabels)
+		s.UploadJitter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_upload_jitter",
+			Help: "The Upload Jitter in milliseconds",
+		}, labels)
+		bandwidthVecs = []prometheus.Collector{s.DownloadBandwidth, s.UploadBandwidth}
+		latencyVecs = []prometheus.Collector{s.DownloadLatency, s.UploadLatency}
+		jitterVecs = []prometheus.Collector{s.DownloadJitter, s.UploadJitter}
+	}
+	s.DownloadMin = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_download_mbps_min",
+		Help: "The lowest Download Rate in Mbps since the tool started or the last reset",
+	}, labels)
+	s.DownloadMax = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_download_mbps_max",
+		Help: "The highest Download Rate in Mbps since the tool started or the last reset",
+	}, labels)
+	s.DownloadEWMA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_download_mbps_ewma",
+		Help: "The exponentially weighted moving average of the Download Rate in Mbps",
+	}, labels)
+	s.UploadEWMA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_upload_mbps_ewma",
+		Help: "The exponentially weighted moving average of the Upload Rate in Mbps",
+	}, labels)
+	s.PingEWMA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_ping_latency_ms_ewma",
+		Help: "The exponentially weighted moving average of the idle Ping Latency in milliseconds",
+	}, labels)
 
 	s.PingLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "speedtest_ping_latency",
-		Help: "The Ping Latency in milliseconds (iqm, low, high)",
-	}, []string{"isp", "server_id", "server_name", "server_location", "latency"})
+		Help: "The Ping Latency in milliseconds while idle (idle, low, high, and p50, p90, p99 when the CLI reports the samples)",
+	}, latencyLabels)
 	s.PingJitter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "speedtest_ping_jitter",
 		Help: "The Ping Jitter in milliseconds",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
+	}, labels)
 
 	s.PacketLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "speedtest_packet_loss",
-		Help: "The Number of Packet Loss",
-	}, []string{"isp", "server_id", "server_name", "server_location"})
+		Help: "The Packet Loss in percentage",
+	}, labels)
+	s.ProbeLoss = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_probe_packet_loss",
+		Help: "The Packet Loss in percentage measured by the built-in probes, with the number of attempts as samples",
+	}, append(slices.Clone(labels), "samples"))
+	s.Retries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_run_retries",
+		Help: "The number of times the last successful run retried the test, e.g. on the next server",
+	})
+	s.CLIOutputBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "speedtest_cli_output_bytes",
+		Help: "The size of the output of the speedtest CLI on the last execution, whether it could be parsed or not",
+	})
+	s.PhaseDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_phase_duration_ms",
+		Help: "The duration in milliseconds of each phase of the test (ping, download, upload); ping includes the server selection",
+	}, append(slices.Clone(labels), "phase"))
+	s.CLIDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_cli_duration_seconds",
+		Help: "The time the speedtest CLI took to run the test, to compare with the duration of the download and upload phases",
+	}, labels)
+	s.AnchorLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_anchor_latency_ms",
+		Help: "The latency in milliseconds to well-known targets, measured before each test",
+	}, []string{"target"})
+	s.ServerFar = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedtest_server_far",
+		Help: "Whether the server is farther than the maximum distance (1) or not (0), when the CLI reports the distance",
+	}, labels)
+	s.PacketLossDist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "speedtest_packet_loss_percent",
+		Help:    "The distribution of the Packet Loss in percentage over the runs",
+		Buckets: []float64{0, 0.5, 1, 2, 5, 10, 25, 50, 100},
+	}, labels)
 
-	prometheus.MustRegister(
+	if s.Registry == nil {
+		s.Registry = prometheus.NewRegistry()
+		s.Registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	s.Registry.MustRegister(
 		s.Requests,
+		s.FailureReasons,
+		s.Failures,
+		s.Successes,
+		s.ConfigInfo,
+		s.HostLoad,
+		s.SinkErrors,
+		s.ServersSkipped,
+		s.BinaryMtime,
+		s.UpdateAvailable,
+		s.Paused,
+		s.NextRun,
+		s.FallbackCacheHit,
+		s.PingLatency,
+		s.ServerFar,
+		s.Retries,
+		s.AnchorLatency,
+		s.CLIDuration,
+		s.CLIOutputBytes,
+	)
+	s.Registry.MustRegister(bandwidthVecs...)
+	s.register(MetricsLatency, latencyVecs...)
+	s.register(MetricsJitter, append(jitterVecs, s.PingJitter)...)
+	s.register(MetricsPacketLoss, s.PacketLoss, s.PacketLossDist, s.ProbeLoss)
+	s.register(MetricsExtremes, s.DownloadMin, s.DownloadMax)
+	s.register(MetricsEWMA, s.DownloadEWMA, s.UploadEWMA, s.PingEWMA)
+	s.register(MetricsElapsed, s.PhaseDuration)
+}
+
+// register registers the collectors of an optional family of metrics, unless it is disabled.
+func (s *PrometheusStats) register(family string, collectors ...prometheus.Collector) {
+	if !s.DisabledMetrics[family] {
+		s.Registry.MustRegister(collectors...)
+	}
+}
+
+// labelValues returns the values of the labels shared by the result gauges.
+func (s *PrometheusStats) labelValues(stats *Stats) []string {
+	c := stats.Server
+	labels := []string{stats.ISP, c.GetID(), c.Name, c.Location, stats.Source, stats.Method}
+	for _, l := range s.ExtraLabels {
+		labels = append(labels, l.Value(stats))
+	}
+	return labels
+}
+
+func (s *PrometheusStats) Update(stats *Stats) {
+	if stats.Server == nil {
+		return
+	}
+
+	labels := s.labelValues(stats)
+	s.setResultTime(labels, stats.Timestamp)
+	latency := func(kind string) []string {
+		return append(slices.Clone(labels), kind)
+	}
+	withRange := !s.DisabledMetrics[MetricsLatencyRange]
+
+	if d := stats.Download; d != nil {
+		s.DownloadBandwidth.WithLabelValues(labels...).Set(d.GetBandWithInMbps())
+		s.updateExtremes(labels, d.GetBandWithInMbps())
+		if d.Latency != nil {
+			s.DownloadLatency.WithLabelValues(latency("iqm")...).Set(d.Latency.IQM)
+			if withRange {
+				s.DownloadLatency.WithLabelValues(latency("low")...).Set(d.Latency.Low)
+				s.DownloadLatency.WithLabelValues(latency("high")...).Set(d.Latency.High)
+			}
+			s.DownloadJitter.WithLabelValues(labels...).Set(d.Latency.Jitter)
+			setPercentiles(s.DownloadLatency, latency, d.Latency.Samples)
+		}
+	}
+
+	if u := stats.Upload; u != nil {
+		s.UploadBandwidth.WithLabelValues(labels...).Set(u.GetBandWithInMbps())
+		if u.Latency != nil {
+			s.UploadLatency.WithLabelValues(latency("iqm")...).Set(u.Latency.IQM)
+			if withRange {
+				s.UploadLatency.WithLabelValues(latency("low")...).Set(u.Latency.Low)
+				s.UploadLatency.WithLabelValues(latency("high")...).Set(u.Latency.High)
+			}
+			s.UploadJitter.WithLabelValues(labels...).Set(u.Latency.Jitter)
+			setPercentiles(s.UploadLatency, latency, u.Latency.Samples)
+		}
+	}
+
+	if p := stats.Ping; p != nil {
+		s.PingLatency.WithLabelValues(latency("idle")...).Set(p.Latency)
+		if withRange {
+			s.PingLatency.WithLabelValues(latency("low")...).Set(p.Low)
+			s.PingLatency.WithLabelValues(latency("high")...).Set(p.High)
+		}
+		s.PingJitter.WithLabelValues(labels...).Set(p.Jitter)
+		setPercentiles(s.PingLatency, latency, p.Samples)
+	}
+
+	if stats.HasPacketLoss() {
+		s.PacketLoss.WithLabelValues(labels...).Set(stats.PacketLoss)
+		s.PacketLossDist.WithLabelValues(labels...).Observe(stats.PacketLoss)
+	}
+	if p := stats.ProbeLoss; p != nil {
+		// The series of a previous run with a different number of attempts would be stale.
+		base := make(prometheus.Labels, len(labels))
+		for i, name := range s.labelNames {
+			base[name] = labels[i]
+		}
+		s.ProbeLoss.DeletePartialMatch(base)
+		s.ProbeLoss.WithLabelValues(append(slices.Clone(labels), strconv.Itoa(p.Samples))...).Set(p.Percent)
+	}
+
+	if stats.Method == MethodFallback {
+		hit := 0.0
+		if stats.CacheHit {
+			hit = 1
+		}
+		s.FallbackCacheHit.Set(hit)
+	}
+
+	if stats.CLIDuration > 0 {
+		s.CLIDuration.WithLabelValues(labels...).Set(stats.CLIDuration.Seconds())
+	}
+	for phase, ms := range stats.PhaseDurations() {
+		s.PhaseDuration.WithLabelValues(append(slices.Clone(labels), phase)...).Set(float64(ms))
+	}
+
+	if s.MaxDistanceKm > 0 && stats.Server.Distance > 0 {
+		far := 0.0
+		if stats.Server.FartherThan(s.MaxDistanceKm) {
+			far = 1
+		}
+		s.ServerFar.WithLabelValues(labels...).Set(far)
+	}
+}
+
+// ClearServer deletes the series of all result gauges for the given server ID, measured
+// on the given interface with the given method, leaving the results of the other interfaces.
+func (s *PrometheusStats) ClearServer(id, iface, method string) {
+	labels := prometheus.Labels{"server_id": id, "interface": iface, "method": method}
+	for _, g := range []*prometheus.GaugeVec{
 		s.DownloadBandwidth,
 		s.DownloadLatency,
 		s.DownloadJitter,
@@ -164,135 +647,632 @@ func (s *PrometheusStats) Init() {
 		s.PingLatency,
 		s.PingJitter,
 		s.PacketLoss,
-	)
+		s.ProbeLoss,
+		s.ServerFar,
+		s.CLIDuration,
+		s.PhaseDuration,
+	} {
+		g.DeletePartialMatch(labels)
+	}
+	s.deleteResultTimes(labels)
 }
 
-func (s *PrometheusStats) Update(stats *Stats) {
-	if stats.HasError() != nil {
-		return
-	}
+// Behaviors for the published gauges when a run fails.
+const (
+	OnFailureRetain = "retain"
+	OnFailureClear  = "clear"
+)
 
-	c := stats.Server
+// Behaviors when the pinned server doesn't exist.
+const (
+	OnMissingServerFail     = "fail"
+	OnMissingServerFallback = "fallback"
+)
 
-	s.DownloadBandwidth.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.Download.GetBandWithInMbps())
-	s.DownloadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "iqm").Set(stats.Download.Latency.IQM)
-	s.DownloadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "low").Set(stats.Download.Latency.Low)
-	s.DownloadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "high").Set(stats.Download.Latency.High)
-	s.DownloadJitter.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.Download.Latency.Jitter)
+// Styles of the download and upload metrics: separate metric names, or a single
+// metric per measurement with a direction label.
+const (
+	MetricStyleSplit    = "split"
+	MetricStyleCombined = "combined"
+)
 
-	s.UploadBandwidth.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.Upload.GetBandWithInMbps())
-	s.UploadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "iqm").Set(stats.Upload.Latency.IQM)
-	s.UploadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "low").Set(stats.Upload.Latency.Low)
-	s.UploadLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "high").Set(stats.Upload.Latency.High)
-	s.UploadJitter.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.Upload.Latency.Jitter)
+// Log levels; debug adds details to troubleshoot differences across hosts.
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
 
-	s.PingLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "iqm").Set(stats.Ping.Latency)
-	s.PingLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "low").Set(stats.Ping.Low)
-	s.PingLatency.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location, "high").Set(stats.Ping.High)
-	s.PingJitter.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.Ping.Jitter)
+type SpeedTester struct {
+	Command         string
+	ServerID        int
+	Servers         []int         // Servers to rotate through, skipping the unreachable ones (instead of ServerID)
+	Frequency       time.Duration // How often the tests are scheduled
+	Align           bool          // Whether the runs are aligned to wall-clock multiples of the frequency
+	Timeout         time.Duration // Maximum duration of each test (0 to wait forever)
+	Interfaces      []string      // Interfaces or source IPs to rotate through on each run
+	ExtraLabels     []ExtraLabel
+	CLIOptions      []CLIOption         // Additional options passed through to the CLI
+	LimitMbps       float64             // Bandwidth limit enforced by the hooks (the CLI cannot limit itself)
+	PreHook         string              // Shell command executed before each test
+	PostHook        string              // Shell command executed after each test
+	TestProxy       *url.URL            // Proxy used by the CLI, passed via the standard environment variables
+	WorkDir         string              // Working and temporary directory of the CLI, when the default is not writable
+	Cgroup          *Cgroup             // When set, bounds the resources of the CLI
+	Fallback        *HTTPSpeedTest      // Measures the throughput via HTTP when the CLI is unavailable
+	Simulator       *SyntheticRunner    // When set, generates synthetic results instead of running the CLI
+	BestOf          int                 // Number of tests per run, publishing only the one with the highest download rate
+	OnFailure       string              // Whether to retain or clear the last published gauges when a run fails
+	OnMissingServer string              // Whether to fail or let the CLI select a server when the pinned one does not exist
+	LoadSource      LoadSource          // When set, the host load is sampled while running the tests
+	MaxLoad         float64             // Load above which results may be CPU-limited (defaults to the number of CPUs)
+	Maintenance     []MaintenanceWindow // Periods during which the scheduled runs are paused
+	MinElapsedMs    int64               // When positive, results with shorter download or upload phases are discarded
+	MaxDistanceKm   float64             // When positive, a warning is logged for results from farther servers
+	DedupWindow     time.Duration       // When positive, runs starting within this time after results were published are skipped
+	StateFile       string              // When set, the streaks are persisted to this file and restored on start
+	DisabledMetrics map[string]bool     // Families of metrics excluded from Metrics()
+	RunTimestamps   bool                // Whether the result series are exposed with the time of the run
+	MaxPingMs       float64             // When positive, the test is skipped if the idle latency measured beforehand is higher
+	PingTarget      string              // host:port used to measure the idle latency (defaults to the last server)
+	Anchors         []string            // host:port of well-known targets whose latency is measured before each test
+	AnchorTimeout   time.Duration       // Maximum time to measure the latency to each anchor
+	LossProbes      int                 // When positive, parallel probes measure the packet loss after each test
+	ProbeTimeout    time.Duration       // Time after which an attempt of the loss probes is counted as lost
+	Sinks           []Sink
+	LogLevel        string        // info or debug
+	Clock           Clock         // Time source of the scheduler and the time-based features (defaults to the real clock)
+	Alerts          *AlertWebhook // When set, notified about failed runs and results below the threshold
+	Events          *EventWriter  // When set, receives the results and the errors of the runs as events
+	DownloadTarget  *crossing     // When set, the transitions of the download rate across its target are reported
+	UploadTarget    *crossing
+	promStats       *PrometheusStats
+	running         sync.Mutex // Serializes the runs
+	EWMAAlpha       float64    // Weight of the latest run on the moving averages
+	MetricStyle     string     // split or combined download and upload metrics
+	averages        map[string]*ewma
+	latest          atomic.Pointer[Stats] // Last published results
+	next            int
+	nextServer      int
+	lastServer      *ServerInfo // Server from the last published results
+	lastAttempt     int         // Server ID of the last configured server tested, 0 when the CLI selects it
+	publishedAt     time.Time   // When the last results were published, only accessed by the runs
+	lastOnDemand    bool        // Whether the last results were published by an on-demand run
+	retries         int         // Executions of the CLI retried by the current run, e.g. on another server
 
-	s.PacketLoss.WithLabelValues(stats.ISP, c.GetID(), c.Name, c.Location).Set(stats.PacketLoss)
+	mu        sync.Mutex // Protects the fields below, exposed via Status()
+	failures  int        // Consecutive failed runs
+	successes int        // Consecutive successful runs
+	lastRunID string
+	lastRun   time.Time
+	lastOK    time.Time // Start of the last successful run
+	lastError string
+	errorTime time.Time
+	nextRun   time.Time
+	session   sessionStats // Aggregates since the tool started, for the shutdown summary
 }
 
-type SpeedTester struct {
-	Command   string
-	ServerID  int
-	promStats *PrometheusStats
+// NextInterface returns the interface or source IP to use on the next run,
+// rotating through the configured list. Returns an empty string when none were configured.
+func (t *SpeedTester) NextInterface() string {
+	if len(t.Interfaces) == 0 {
+		return ""
+	}
+	iface := t.Interfaces[t.next%len(t.Interfaces)]
+	t.next++
+	return iface
 }
 
+// Run performs a scheduled test, waiting for any run in progress to finish.
 func (t *SpeedTester) Run() error {
-	log.Println("Starting speed test")
+	t.running.Lock()
+	defer t.running.Unlock()
+	_, err := t.run(nil, false)
+	return err
+}
+
+// runScheduled performs a scheduled run, logging its error, unless it falls within a maintenance window.
+func (t *SpeedTester) runScheduled(now time.Time) {
+	if t.inMaintenance(now) {
+		log.Println("Paused for maintenance, skipping the scheduled run")
+		t.Metrics().Paused.Set(1)
+		return
+	}
+	t.Metrics().Paused.Set(0)
+	if err := t.Run(); err != nil && !errors.Is(err, ErrDeduplicated) {
+		log.Printf("cannot execute command (reason=%s): %v", FailureReason(err), err)
+	}
+}
+
+// TryRun performs an on-demand test with the given annotations attached to the results,
+// unless another run is in progress.
+func (t *SpeedTester) TryRun(annotations map[string]string) (*Stats, error) {
+	if !t.running.TryLock() {
+		return nil, ErrBusy
+	}
+	defer t.running.Unlock()
+	return t.run(annotations, true)
+}
+
+func (t *SpeedTester) run(annotations map[string]string, onDemand bool) (_ *Stats, err error) {
+	id := NewRunID()
+	logger := log.New(log.Writer(), "run="+id+" ", log.Flags()|log.Lmsgprefix)
+	logger.Println("Starting speed test")
+	if len(annotations) > 0 {
+		logger.Printf("Annotations: %s", formatAnnotations(annotations))
+	}
+	// Checked before the test, so a run queued behind another one, e.g. a scheduled run
+	// waiting for an on-demand one, is skipped rather than measured again. It is neither
+	// a success nor a failure, so the streaks are not affected. Consecutive scheduled runs
+	// are never duplicates, however close a short frequency brings them.
+	if last := t.Latest(); last != nil && t.DedupWindow > 0 && (onDemand || t.lastOnDemand) {
+		if since := t.clock().Now().Sub(t.publishedAt); since < t.DedupWindow {
+			logger.Printf("Skipping the test, as run %s published results %s ago (within the dedup window of %s)", last.RunID, since.Round(time.Second), t.DedupWindow)
+			t.promStats.Requests.WithLabelValues("deduplicated").Inc()
+			return last, ErrDeduplicated
+		}
+	}
 
-	status := "error"
+	start := t.clock().Now()
+	var source, method string // Of the last test attempted, identifying its series with the server
 	defer func() {
+		status := "ok"
+		if err != nil {
+			status = "error"
+			t.promStats.FailureReasons.WithLabelValues(FailureReason(err)).Inc()
+			if t.OnFailure == OnFailureClear && !errors.Is(err, ErrSkippedSlow) {
+				t.clear(logger, source, method)
+			}
+		}
+		failures, successes := t.record(id, start, err)
+		if t.StateFile != "" {
+			if err := t.saveState(failures, successes); err != nil {
+				logger.Printf("cannot save the state: %v", err)
+			}
+		}
 		t.promStats.Requests.WithLabelValues(status).Inc()
+		t.promStats.Failures.Set(float64(failures))
+		t.promStats.Successes.Set(float64(successes))
+		if err != nil {
+			t.alert(logger, Alert{Reason: FailureReason(err), Message: err.Error(), RunID: id, Annotations: annotations})
+			if t.Events != nil {
+				t.Events.Error(id, err, failures, annotations)
+			}
+		}
 	}()
 
 	if t.Command == "" {
 		t.Command = "/usr/bin/speedtest"
 	}
-	if t.promStats == nil {
-		t.promStats = new(PrometheusStats)
-		t.promStats.Init()
+	t.Metrics()
+	t.updateBinaryMtime(logger)
+
+	iface := t.NextInterface()
+	t.retries = 0
+	if t.MaxPingMs > 0 && t.Simulator == nil {
+		if stats, err := t.precheck(logger, iface); err != nil {
+			logger.Printf("%v, publishing only the ping results", err)
+			stats.RunID = id
+			stats.Annotations = annotations
+			stats.Timestamp = t.clock().Now()
+			t.promStats.Atomically(func() { t.promStats.Update(stats) })
+			return nil, err
+		}
 	}
+	if len(t.Anchors) > 0 && t.Simulator == nil {
+		t.measureAnchors(logger, iface)
+	}
+	runs := max(t.BestOf, 1)
+	var results []*Stats
+	var lastErr error
+	for i := range runs {
+		if runs > 1 {
+			logger.Printf("Running test %d of %d", i+1, runs)
+		}
+		var stats *Stats
+		var err error
+		if t.Simulator != nil {
+			source, method = "", MethodSimulated
+			stats, err = t.Simulator.Run(logger)
+		} else {
+			source, method = iface, MethodOokla
+			stats, err = t.executeServers(logger, iface)
+		}
+		if errors.Is(err, ErrServerNotFound) && t.OnMissingServer == OnMissingServerFallback {
+			logger.Printf("%v (reason=server_not_found), letting the CLI select a server", err)
+			t.retries++
+			stats, err = t.execute(logger, 0, iface)
+		}
+		if errors.Is(err, ErrCLIUnavailable) && t.Fallback != nil {
+			logger.Printf("%v, using the HTTP fallback", err)
+			source, method = "", MethodFallback
+			stats, err = t.Fallback.Run(logger)
+		}
+		if err == nil {
+			err = t.checkElapsed(stats)
+		}
+		if err != nil {
+			if runs > 1 {
+				logger.Printf("test %d failed: %v", i+1, err)
+			}
+			lastErr = err
+			continue
+		}
+		stats.RunID = id
+		stats.Annotations = annotations
+		results = append(results, stats)
+	}
+	if len(results) == 0 {
+		return nil, lastErr
+	}
+
+	stats := BestOf(results)
+	if runs > 1 {
+		logger.Printf("Publishing the best of %d successful tests (%.2f Mbps)", len(results), stats.Download.GetBandWithInMbps())
+	}
+	if t.LossProbes > 0 && t.Simulator == nil {
+		t.measureProbeLoss(logger, stats)
+	}
+	t.publishResults(stats)
+	t.lastOnDemand = onDemand
+	if stats.Server.FartherThan(t.MaxDistanceKm) {
+		logger.Printf("warning: server %s (%s) is %.0f km away, farther than %.0f km, consider pinning a closer server with --server", stats.Server.GetID(), stats.Server.Name, stats.Server.Distance, t.MaxDistanceKm)
+	}
+	t.accumulate(stats)
+	t.lastServer = stats.Server
+	for _, sink := range t.Sinks {
+		if err := sink.Publish(stats); err != nil {
+			logger.Printf("cannot publish results to %s: %v", sink.Name(), err)
+			t.promStats.SinkErrors.WithLabelValues(sink.Name()).Inc()
+		}
+	}
+	if t.Events != nil {
+		t.Events.Result(stats)
+	}
+	t.checkCrossings(logger, stats)
+	if t.Alerts != nil && t.Alerts.Breached(stats) {
+		t.alert(logger, Alert{
+			Reason:      AlertReasonLowDownload,
+			Message:     fmt.Sprintf("download rate %.2f Mbps is below %.2f Mbps", stats.Download.GetBandWithInMbps(), t.Alerts.MinDownloadMbps),
+			RunID:       id,
+			Results:     stats,
+			Annotations: annotations,
+		})
+	}
+	return stats, nil
+}
 
+// Arguments of every execution of the CLI, with the minimal output: no progress,
+// which some builds are slow to render, and only the results as JSON. The options
+// passed through with --cli-option cannot change them.
+var baseCLIArgs = []string{"--accept-license", "--progress=no", "--format=json"}
+
+// execute runs the speedtest CLI once, optionally bound to the given interface,
+// and returns the parsed results.
+func (t *SpeedTester) execute(logger *log.Logger, serverID int, iface string) (*Stats, error) {
 	start := time.Now()
 
-	args := []string{"--accept-license", "--progress=no", "--format=json"}
-	if t.ServerID > 0 {
-		log.Printf("Using Server ID %d", t.ServerID)
-		args = append(args, []string{"--server-id", strconv.Itoa(t.ServerID)}...)
+	args := slices.Clone(baseCLIArgs)
+	if serverID > 0 {
+		logger.Printf("Using Server ID %d", serverID)
+		args = append(args, []string{"--server-id", strconv.Itoa(serverID)}...)
+	}
+	if iface != "" {
+		logger.Printf("Using interface %s", iface)
+		args = append(args, interfaceArgs(iface)...)
+	}
+	for _, o := range t.CLIOptions {
+		args = append(args, o.Arg())
+	}
+	ctx := context.Background()
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+
+	env := hookEnv(t.LimitMbps, iface)
+	if t.PostHook != "" {
+		defer func() {
+			if err := runHook(context.Background(), t.PostHook, env); err != nil {
+				logger.Printf("post-hook failed: %v", err)
+			}
+		}()
+	}
+	if t.PreHook != "" {
+		if err := runHook(ctx, t.PreHook, env); err != nil {
+			return nil, fmt.Errorf("%w: pre-hook failed: %v", ErrExec, err)
+		}
+	}
+
+	t.debugf(logger, "Executing %s with arguments %q", t.Command, args)
+	cmdCtx, cancelCmd := context.WithCancel(ctx)
+	defer cancelCmd()
+	cmd := exec.CommandContext(cmdCtx, t.Command, args...)
+	cmd.WaitDelay = time.Second // Don't wait for the children of a killed CLI that still hold its output
+	var cmdEnv []string
+	if t.TestProxy != nil {
+		logger.Printf("Using proxy %s", t.TestProxy.Redacted())
+		cmdEnv = append(cmdEnv, proxyEnv(t.TestProxy)...)
+	}
+	if t.WorkDir != "" {
+		cmd.Dir = t.WorkDir
+		cmdEnv = append(cmdEnv, workDirEnv(t.WorkDir)...)
+	}
+	if t.Cgroup != nil {
+		t.Cgroup.Apply(cmd)
+	}
+	if len(cmdEnv) > 0 {
+		cmd.Env = append(os.Environ(), cmdEnv...)
 	}
-	cmd := exec.Command(t.Command, args...)
 	out := new(bytes.Buffer)
-	cmd.Stdout = out
+	errOut := new(bytes.Buffer)
+	var prompted atomic.Bool
+	cmd.Stdout = &promptWatcher{buf: out, prompted: &prompted, cancel: cancelCmd}
+	cmd.Stderr = &promptWatcher{buf: errOut, prompted: &prompted, cancel: cancelCmd}
 
-	if err := cmd.Run(); err != nil {
-		return err
+	var stopSampling func() (float64, error)
+	if t.LoadSource != nil {
+		stopSampling = sampleLoad(t.LoadSource, 5*time.Second)
+	}
+	cliStart := time.Now()
+	err := cmd.Run()
+	cliDuration := time.Since(cliStart)
+	t.promStats.CLIOutputBytes.Set(float64(out.Len()))
+	if stopSampling != nil {
+		peak, loadErr := stopSampling()
+		t.checkLoad(logger, peak, loadErr)
+	}
+	if prompted.Load() {
+		return nil, fmt.Errorf("%w: the CLI is prompting to accept the license despite --accept-license, run it once interactively as the same user to accept it", ErrValidation)
+	}
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%w after %s", ErrTimeout, t.Timeout)
+		}
+		stderr := strings.TrimSpace(errOut.String())
+		switch {
+		case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) || strings.Contains(strings.ToLower(stderr), "license"):
+			err = fmt.Errorf("%w: %v", ErrCLIUnavailable, err)
+		case serverID > 0 && serverNotFoundRegex.MatchString(stderr):
+			err = fmt.Errorf("%w: %v", ErrServerNotFound, err)
+		case unreachableRegex.MatchString(stderr):
+			err = fmt.Errorf("%w: %v", ErrUnreachable, err)
+		default:
+			err = fmt.Errorf("%w: %v", ErrExec, err)
+		}
+		if stderr != "" {
+			return nil, fmt.Errorf("%w: %s", err, stderr)
+		}
+		return nil, err
 	}
 
 	stats := new(Stats)
 	if err := json.Unmarshal(out.Bytes(), stats); err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrParse, err)
 	}
 
-	stats.Log()
+	stats.Source = iface
+	stats.Method = MethodOokla
+	stats.CLIDuration = cliDuration
+	stats.Log(logger)
 	elapsed := time.Since(start)
-	log.Printf("Finished in %s", elapsed.String())
+	logger.Printf("Finished in %s", elapsed.String())
+	if stats.Download != nil && stats.Upload != nil {
+		phases := time.Duration(stats.Download.Elapsed+stats.Upload.Elapsed) * time.Millisecond
+		t.debugf(logger, "The CLI ran for %s, %s of them in the download and upload phases", cliDuration.Round(time.Millisecond), phases)
+	}
 	if err := stats.HasError(); err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrValidation, err)
+	}
+	return stats, nil
+}
+
+// checkLoad publishes the peak load observed during a test, warning when it exceeds the threshold.
+func (t *SpeedTester) checkLoad(logger *log.Logger, load float64, err error) {
+	if err != nil {
+		logger.Printf("cannot sample host load: %v", err)
+		return
+	}
+	t.promStats.HostLoad.Set(load)
+	threshold := t.MaxLoad
+	if threshold <= 0 {
+		threshold = float64(runtime.NumCPU())
+	}
+	if load > threshold {
+		logger.Printf("WARNING: host load reached %.2f during the test (threshold %.2f), results may be CPU-limited", load, threshold)
+	}
+}
+
+// debugf logs the message only when the log level is debug.
+func (t *SpeedTester) debugf(logger *log.Logger, format string, v ...any) {
+	if t.LogLevel == LogLevelDebug {
+		logger.Printf("debug: "+format, v...)
+	}
+}
+
+// checkElapsed returns ErrTooShort when the download or upload phases took less than
+// the minimum, as the test was likely aborted and the rates are unreliable.
+func (t *SpeedTester) checkElapsed(stats *Stats) error {
+	if t.MinElapsedMs <= 0 {
+		return nil
+	}
+	phases := []struct {
+		name  string
+		stats *BandwidthStats
+	}{{"download", stats.Download}, {"upload", stats.Upload}}
+	for _, p := range phases {
+		if p.stats != nil && p.stats.Elapsed < t.MinElapsedMs {
+			return fmt.Errorf("%w: %s took %d ms, expected at least %d ms", ErrTooShort, p.name, p.stats.Elapsed, t.MinElapsedMs)
+		}
 	}
-	t.promStats.Update(stats)
-	status = "ok"
 	return nil
 }
 
-func main() {
-	var prometheusPort int
-	var updateFrequency time.Duration
-	runner := new(SpeedTester)
-
-	flag.IntVar(&prometheusPort, "port", 8080, "HTTP Port to expose statistics via Prometheus")
-	flag.DurationVar(&updateFrequency, "frequency", 15*time.Minute, "Frequency on which statistics are retrieved and proceessed")
-	flag.IntVar(&runner.ServerID, "server", 0, "Ookla Server ID (must be listed on the output of 'speedtest --servers')")
-	flag.StringVar(&runner.Command, "path", "/usr/bin/speedtest", "Ookla Speed Test CLI Path ID")
-	flag.Parse()
-
-	ctx, cancel := context.WithCancel(context.Background())
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
-	defer func() {
-		signal.Stop(signalChan)
-	}()
+// BestOf returns the result with the highest download rate, or nil when there are no results with download details.
+func BestOf(results []*Stats) *Stats {
+	var best *Stats
+	for _, stats := range results {
+		if stats == nil || stats.Download == nil {
+			continue
+		}
+		if best == nil || stats.Download.Bandwidth > best.Download.Bandwidth {
+			best = stats
+		}
+	}
+	return best
+}
+
+// NewRunID returns a random UUID (version 4) to identify a run across logs and sinks.
+func NewRunID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
 
-	go func() {
-		log.Printf("Starting Prometheus Metrics server on port %d", prometheusPort)
-		http.Handle("/", promhttp.Handler())
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", prometheusPort), nil); err != nil {
-			log.Fatalf("Cannot start prometheus HTTP server: %v", err)
+// Metrics returns the Prometheus statistics updated by the runner, initializing them when needed.
+func (t *SpeedTester) Metrics() *PrometheusStats {
+	if t.promStats == nil {
+		t.promStats = &PrometheusStats{ExtraLabels: t.ExtraLabels, MetricStyle: t.MetricStyle, MaxDistanceKm: t.MaxDistanceKm, DisabledMetrics: t.DisabledMetrics, RunTimestamps: t.RunTimestamps}
+		t.promStats.Init()
+		var options []string
+		for _, o := range t.CLIOptions {
+			options = append(options, o.Name)
 		}
-	}()
+		limit := ""
+		if t.LimitMbps > 0 {
+			limit = strconv.FormatFloat(t.LimitMbps, 'f', -1, 64)
+		}
+		t.promStats.ConfigInfo.WithLabelValues(strings.Join(options, ","), limit, strconv.FormatBool(t.TestProxy != nil)).Set(1)
+		t.mu.Lock()
+		t.promStats.Failures.Set(float64(t.failures))
+		t.promStats.Successes.Set(float64(t.successes))
+		t.mu.Unlock()
+		t.promStats.Registry.MustRegister(newResultAgeCollector(t.lastSuccess, func() time.Time { return t.clock().Now() }))
+	}
+	return t.promStats
+}
 
-	go func() {
-		log.Printf("Statistics will be collected and processed every %s", updateFrequency.String())
-		if err := runner.Run(); err != nil {
-			log.Printf("cannot execute command: %s", err)
+// updateBinaryMtime publishes the modification time of the CLI binary, to spot hosts running stale versions.
+func (t *SpeedTester) updateBinaryMtime(logger *log.Logger) {
+	if t.Simulator != nil {
+		return
+	}
+	path, err := exec.LookPath(t.Command)
+	if err == nil {
+		var info os.FileInfo
+		if info, err = os.Stat(path); err == nil {
+			t.promStats.BinaryMtime.Set(float64(info.ModTime().Unix()))
+			return
 		}
-		ticker := time.NewTicker(updateFrequency)
-		for {
-			select {
-			case <-ctx.Done():
-				ticker.Stop()
-				return
-			case <-ticker.C:
-				if err := runner.Run(); err != nil {
-					log.Printf("cannot execute command: %v", err)
-				}
-			}
+	}
+	logger.Printf("cannot stat speedtest binary: %v", err)
+}
+
+// clear removes the published gauges of the failed server on the interface and with the method
+// of the failed run, so graphs show a gap instead of stale values.
+func (t *SpeedTester) clear(logger *log.Logger, iface, method string) {
+	var id string
+	if t.lastServer != nil {
+		id = t.lastServer.GetID()
+	}
+	if t.lastAttempt > 0 {
+		id = strconv.Itoa(t.lastAttempt)
+	}
+	if id != "" {
+		logger.Printf("Clearing statistics for Server ID %s", id)
+		t.promStats.Atomically(func() { t.promStats.ClearServer(id, iface, method) })
+	}
+}
+
+// record updates the status and the consecutive failures/successes streaks after a run.
+func (t *SpeedTester) record(id string, start time.Time, err error) (failures, successes int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastRunID = id
+	t.lastRun = start
+	t.session.runs++
+	if err == nil {
+		t.successes++
+		t.failures = 0
+		t.lastOK = start
+	} else {
+		t.failures++
+		t.successes = 0
+		t.session.failures++
+		t.lastError = err.Error()
+		t.errorTime = start
+	}
+	return t.failures, t.successes
+}
+
+// alert completes the alert with the recent history and sends it to the alert webhook, if any.
+func (t *SpeedTester) alert(logger *log.Logger, alert Alert) {
+	if t.Alerts == nil {
+		return
+	}
+	t.Alerts.Notify(logger, t.completeAlert(alert))
+}
+
+// completeAlert sets the time of the alert and the recent history.
+func (t *SpeedTester) completeAlert(alert Alert) Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	alert.Time = t.clock().Now()
+	alert.ConsecutiveFailures = t.failures
+	alert.LastSuccess = timeOrNil(t.lastOK)
+	return alert
+}
+
+// lastSuccess returns the start of the last successful run, zero when there was none.
+func (t *SpeedTester) lastSuccess() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.lastOK
+}
+
+// Schedule records when the next run is expected, to be exposed via Status() and
+// as a timestamp for the dashboards to show a countdown.
+func (t *SpeedTester) Schedule(next time.Time) {
+	t.Metrics().NextRun.Set(float64(next.Unix()))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nextRun = next
+}
+
+// interfaceArgs returns the CLI arguments to bind the test to the given interface
+// name or source IP address.
+func interfaceArgs(iface string) []string {
+	if net.ParseIP(iface) != nil {
+		return []string{"--ip", iface}
+	}
+	return []string{"--interface", iface}
+}
+
+// listFlag is a flag.Value that accepts comma-separated values and can be repeated.
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
 		}
-	}()
+	}
+	return nil
+}
 
-	<-signalChan
-	cancel()
-	log.Println("Good bye")
+func main() {
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.Run(args); err != nil {
+		log.Fatalf("%s: %v", cmd.Name, err)
+	}
 }
@@ -0,0 +1,1287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// loadResult parses a result of the CLI from testdata, like execute does.
+func loadResult(t *testing.T, name string) *Stats {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := new(Stats)
+	if err := json.Unmarshal(data, stats); err != nil {
+		t.Fatal(err)
+	}
+	stats.Method = MethodOokla
+	return stats
+}
+
+// fakeCLI writes an executable shell script standing in for the CLI, skipping the
+// test on the platforms without a shell.
+func fakeCLI(t *testing.T, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake CLI requires a shell")
+	}
+	path := filepath.Join(t.TempDir(), "speedtest")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+strings.TrimSpace(script)+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fixtureCLI returns a fake CLI printing the given fixture from testdata.
+func fixtureCLI(t *testing.T, name string) string {
+	t.Helper()
+	path, err := filepath.Abs(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeCLI(t, "cat '"+path+"'")
+}
+
+// testLogger returns a logger writing to the log of the test.
+func testLogger(t *testing.T) *log.Logger {
+	return log.New(testWriter{t}, "", 0)
+}
+
+type testWriter struct {
+	t *testing.T
+}
+
+func (w testWriter) Write(p []byte) (int, error) {
+	w.t.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func TestRunDeduplicated(t *testing.T) {
+	dir := t.TempDir()
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, started, gate := filepath.Join(dir, "count"), filepath.Join(dir, "started"), filepath.Join(dir, "gate")
+	// The CLI blocks until the gate exists, so the scheduled run queues behind the on-demand one.
+	cli := fakeCLI(t, `
+echo run >> '`+count+`'
+touch '`+started+`'
+while [ ! -f '`+gate+`' ]; do sleep 0.01; done
+cat '`+fixture+`'`)
+	tester := newLoopTester(newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+	tester.Simulator = nil
+	tester.Command = cli
+	tester.DedupWindow = 30 * time.Second
+
+	onDemand := make(chan error, 1)
+	go func() {
+		_, err := tester.TryRun(map[string]string{"reason": "manual"})
+		onDemand <- err
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(started); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the on-demand run")
+		}
+	}
+	scheduled := make(chan error, 1)
+	go func() { scheduled <- tester.Run() }()
+	if err := os.WriteFile(gate, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-onDemand; err != nil {
+		t.Fatalf("on-demand run: %v", err)
+	}
+	if err := <-scheduled; !errors.Is(err, ErrDeduplicated) {
+		t.Fatalf("scheduled run: got %v, want %v", err, ErrDeduplicated)
+	}
+	data, err := os.ReadFile(count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runs := strings.Count(string(data), "run"); runs != 1 {
+		t.Errorf("the CLI ran %d times, want 1", runs)
+	}
+	metrics := tester.Metrics()
+	for status, want := range map[string]float64{"ok": 1, "error": 0, "deduplicated": 1} {
+		if got := testutil.ToFloat64(metrics.Requests.WithLabelValues(status)); got != want {
+			t.Errorf("speedtest_total_requests{status=%q} = %v, want %v", status, got, want)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.Successes); got != 1 {
+		t.Errorf("the success streak is %v, want 1", got)
+	}
+	if latest := tester.Latest(); latest == nil || latest.Annotations["reason"] != "manual" {
+		t.Errorf("the latest results lost the annotations of the on-demand run: %+v", latest)
+	}
+}
+
+func TestRunDeduplicatedOnDemand(t *testing.T) {
+	tests := []struct {
+		name             string
+		first, second    bool // Whether each run is on-demand
+		wantDeduplicated bool
+	}{
+		{name: "scheduled after scheduled"},
+		{name: "scheduled after on-demand", first: true, wantDeduplicated: true},
+		{name: "on-demand after scheduled", second: true, wantDeduplicated: true},
+		{name: "on-demand after on-demand", first: true, second: true, wantDeduplicated: true},
+	}
+	run := func(tester *SpeedTester, onDemand bool) error {
+		if onDemand {
+			_, err := tester.TryRun(nil)
+			return err
+		}
+		return tester.Run()
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The fake clock doesn't move, so the second run always starts within the window.
+			tester := newLoopTester(newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+			tester.DedupWindow = 30 * time.Second
+			if err := run(tester, tt.first); err != nil {
+				t.Fatal(err)
+			}
+			err := run(tester, tt.second)
+			if got := errors.Is(err, ErrDeduplicated); got != tt.wantDeduplicated {
+				t.Fatalf("got error %v, want deduplicated: %t", err, tt.wantDeduplicated)
+			}
+			if !tt.wantDeduplicated && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// populateMetrics sets a series on every metric of the given statistics.
+func populateMetrics(t *testing.T, s *PrometheusStats) {
+	t.Helper()
+	stats := loadResult(t, "result.json")
+	samples := LatencySamples{8, 9, 10, 11, 12, 13, 14, 15, 16, 40}
+	stats.Download.Latency.Samples = samples
+	stats.Upload.Latency.Samples = samples
+	stats.Ping.Samples = samples
+	stats.PacketLoss = 0.5
+	stats.ProbeLoss = &ProbeLoss{Percent: 1, Samples: 30}
+	stats.CLIDuration = 25 * time.Second
+	stats.Server.Distance = 120
+	s.Update(stats)
+
+	stats.Method = MethodFallback
+	s.Update(stats)
+	labels := s.labelValues(stats)
+	s.DownloadEWMA.WithLabelValues(labels...).Set(100)
+	s.UploadEWMA.WithLabelValues(labels...).Set(20)
+	s.PingEWMA.WithLabelValues(labels...).Set(10)
+	s.Requests.WithLabelValues("ok").Inc()
+	s.FailureReasons.WithLabelValues("timeout").Inc()
+	s.ConfigInfo.WithLabelValues("", "0", "").Set(1)
+	s.SinkErrors.WithLabelValues("syslog").Inc()
+	s.ServersSkipped.WithLabelValues("14774").Inc()
+	s.UpdateAvailable.WithLabelValues("v1.2.3").Set(1)
+	s.AnchorLatency.WithLabelValues("1.1.1.1:53").Set(5)
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	validName := regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// Labels that result metrics may add to the shared ones.
+	qualifiers := []string{"latency", "direction", "samples", "phase"}
+	for _, style := range []string{MetricStyleSplit, MetricStyleCombined} {
+		t.Run(style, func(t *testing.T) {
+			extraLabels, err := ParseExtraLabels([]string{"server.country=country"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &PrometheusStats{ExtraLabels: extraLabels, MetricStyle: style, MaxDistanceKm: 100}
+			s.Init()
+			populateMetrics(t, s)
+			shared := []string{"isp", "server_id", "server_name", "server_location", "interface", "method", "country"}
+
+			families, err := s.Registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gathered := 0
+			for _, family := range families {
+				name := family.GetName()
+				if !strings.HasPrefix(name, "speedtest_") {
+					continue // The collectors of the Go runtime and the process
+				}
+				gathered++
+				help := family.GetHelp()
+				if help == "" || strings.ToUpper(help[:1]) != help[:1] || strings.HasSuffix(help, ".") {
+					t.Errorf("%s: the help %q must be a capitalized phrase without a final period", name, help)
+				}
+				labelNames := make(map[string]bool)
+				latencies := make(map[string]bool)
+				for _, metric := range family.GetMetric() {
+					for _, pair := range metric.GetLabel() {
+						labelNames[pair.GetName()] = true
+						if pair.GetName() == "latency" {
+							latencies[pair.GetValue()] = true
+						}
+					}
+				}
+				for label := range labelNames {
+					if !validName.MatchString(label) {
+						t.Errorf("%s: invalid label name %q", name, label)
+					}
+				}
+				if labelNames["isp"] {
+					for _, label := range shared {
+						if !labelNames[label] {
+							t.Errorf("%s: the result metric lacks the shared label %q", name, label)
+						}
+					}
+					for label := range labelNames {
+						if !slices.Contains(shared, label) && !slices.Contains(qualifiers, label) {
+							t.Errorf("%s: unexpected label %q on a result metric", name, label)
+						}
+					}
+				}
+				if len(latencies) > 0 {
+					if documented := helpValues(help); !maps.Equal(documented, latencies) {
+						t.Errorf("%s: the help documents the latency values %v, but the metric has %v", name, slices.Sorted(maps.Keys(documented)), slices.Sorted(maps.Keys(latencies)))
+					}
+				}
+			}
+			if want := len(describedMetrics(s)); gathered != want {
+				t.Errorf("gathered %d families of metrics, want all the %d described by the statistics", gathered, want)
+			}
+		})
+	}
+}
+
+// helpValues returns the values listed between parentheses on a help string, like
+// "(iqm, low, high, and p50, p90, p99 when the CLI reports the samples)".
+func helpValues(help string) map[string]bool {
+	values := make(map[string]bool)
+	_, list, ok := strings.Cut(help, "(")
+	if !ok {
+		return values
+	}
+	list, _, _ = strings.Cut(list, ")")
+	for _, item := range strings.Split(list, ",") {
+		if fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(item), "and ")); len(fields) > 0 {
+			values[fields[0]] = true
+		}
+	}
+	return values
+}
+
+// describedMetrics returns the descriptions of all the metrics of the statistics, as
+// found on its collector fields, so a new metric cannot be left out of the checks.
+func describedMetrics(s *PrometheusStats) map[string]bool {
+	descs := make(map[string]bool)
+	v := reflect.ValueOf(s).Elem()
+	for i := range v.NumField() {
+		field := v.Field(i)
+		if !v.Type().Field(i).IsExported() || field.Kind() != reflect.Pointer && field.Kind() != reflect.Interface || field.IsNil() {
+			continue
+		}
+		collector, ok := field.Interface().(prometheus.Collector)
+		if _, registry := collector.(*prometheus.Registry); !ok || registry {
+			continue
+		}
+		ch := make(chan *prometheus.Desc)
+		go func() {
+			collector.Describe(ch)
+			close(ch)
+		}()
+		for desc := range ch {
+			descs[desc.String()] = true
+		}
+	}
+	return descs
+}
+
+func TestExecuteArgs(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		serverID   int
+		iface      string
+		cliOptions []string
+		want       []string // Expected arguments besides the default ones
+	}{
+		{name: "default"},
+		{name: "pinned server", serverID: 14774, want: []string{"--server-id", "14774"}},
+		{name: "interface", iface: "eth0", want: []string{"--interface", "eth0"}},
+		{name: "source IP", iface: "192.168.1.2", want: []string{"--ip", "192.168.1.2"}},
+		{name: "passthrough options", cliOptions: []string{"ca-certificate=/etc/ssl/ca.pem"}, want: []string{"--ca-certificate=/etc/ssl/ca.pem"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := filepath.Join(t.TempDir(), "args")
+			tester := &SpeedTester{Command: fakeCLI(t, `printf '%s\n' "$@" > '`+args+`'; cat '`+fixture+`'`)}
+			if tester.CLIOptions, err = ParseCLIOptions(tt.cliOptions); err != nil {
+				t.Fatal(err)
+			}
+			tester.Metrics()
+			stats, err := tester.execute(testLogger(t), tt.serverID, tt.iface)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.CLIDuration <= 0 {
+				t.Errorf("got CLI duration %s, want it measured", stats.CLIDuration)
+			}
+			data, err := os.ReadFile(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Fields(string(data))
+			if want := append(slices.Clone(baseCLIArgs), tt.want...); !slices.Equal(got, want) {
+				t.Errorf("got arguments %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBaseCLIArgsCannotBeOverridden(t *testing.T) {
+	for _, arg := range []string{"--progress=no", "--format=json"} {
+		if !slices.Contains(baseCLIArgs, arg) {
+			t.Errorf("the default arguments %q lack %s", baseCLIArgs, arg)
+		}
+	}
+	for _, entry := range []string{"progress=yes", "--format=human-readable", "format=jsonl", "accept-license=no", "verbose=1"} {
+		if _, err := ParseCLIOptions([]string{entry}); err == nil {
+			t.Errorf("ParseCLIOptions(%q) succeeded, want it rejected", entry)
+		}
+	}
+}
+
+func TestInterfaceArgs(t *testing.T) {
+	tests := []struct {
+		iface string
+		want  []string
+	}{
+		{"eth0", []string{"--interface", "eth0"}},
+		{"wwan0", []string{"--interface", "wwan0"}},
+		{"192.168.1.2", []string{"--ip", "192.168.1.2"}},
+		{"fe80::1", []string{"--ip", "fe80::1"}},
+	}
+	for _, tt := range tests {
+		if got := interfaceArgs(tt.iface); !slices.Equal(got, tt.want) {
+			t.Errorf("interfaceArgs(%q) = %q, want %q", tt.iface, got, tt.want)
+		}
+	}
+}
+
+func TestInterfaceRotation(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := filepath.Join(t.TempDir(), "args")
+	// Logs the binding arguments of each execution, after the default ones.
+	tester := &SpeedTester{Command: fakeCLI(t, `echo "$4 $5" >> '`+args+`'; cat '`+fixture+`'`), Interfaces: []string{"eth0", "192.168.1.2"}}
+	metrics := tester.Metrics()
+	for range 3 {
+		if err := tester.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Split(strings.TrimSpace(string(data)), "\n"), []string{"--interface eth0", "--ip 192.168.1.2", "--interface eth0"}; !slices.Equal(got, want) {
+		t.Errorf("got the bindings %q, want %q", got, want)
+	}
+	for _, iface := range []string{"eth0", "192.168.1.2"} {
+		labels := []string{"Acme", "14774", "UNC Chapel Hill", "Chapel Hill, NC", iface, MethodOokla}
+		if got := testutil.ToFloat64(metrics.DownloadBandwidth.WithLabelValues(labels...)); got != 100 {
+			t.Errorf("speedtest_download_speed{interface=%q} = %v, want 100", iface, got)
+		}
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	tester := newLoopTester(realClock{})
+	metrics := tester.Metrics()
+	steps := []struct {
+		fail                bool
+		failures, successes float64
+	}{
+		{false, 0, 1},
+		{false, 0, 2},
+		{true, 1, 0},
+		{false, 0, 1},
+		{true, 1, 0},
+		{true, 2, 0},
+		{false, 0, 1},
+	}
+	for i, step := range steps {
+		tester.Simulator.FailureRate = 0
+		if step.fail {
+			tester.Simulator.FailureRate = 1
+		}
+		if err := tester.Run(); (err != nil) != step.fail {
+			t.Fatalf("run %d: got error %v, want a failure: %t", i+1, err, step.fail)
+		}
+		if got := testutil.ToFloat64(metrics.Failures); got != step.failures {
+			t.Errorf("run %d: speedtest_consecutive_failures = %v, want %v", i+1, got, step.failures)
+		}
+		if got := testutil.ToFloat64(metrics.Successes); got != step.successes {
+			t.Errorf("run %d: speedtest_consecutive_successes = %v, want %v", i+1, got, step.successes)
+		}
+	}
+}
+
+func TestBestOf(t *testing.T) {
+	result := func(bandwidth int64) *Stats {
+		return &Stats{Download: &BandwidthStats{Bandwidth: bandwidth}}
+	}
+	slow, fast, faster := result(1_000_000), result(12_500_000), result(12_500_001)
+	tests := []struct {
+		name    string
+		results []*Stats
+		want    *Stats
+	}{
+		{name: "none"},
+		{name: "single", results: []*Stats{slow}, want: slow},
+		{name: "highest download rate", results: []*Stats{slow, faster, fast}, want: faster},
+		{name: "first of equal rates", results: []*Stats{fast, result(12_500_000)}, want: fast},
+		{name: "without download details", results: []*Stats{nil, {}, slow}, want: slow},
+		{name: "only without download details", results: []*Stats{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BestOf(tt.results); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunBestOf(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		rates []string // Download rate in bytes per second of each test, or fail
+		want  float64  // Published download rate in Mbps
+	}{
+		{name: "highest of all", rates: []string{"5000000", "15000000", "10000000"}, want: 120},
+		{name: "highest of the successful ones", rates: []string{"5000000", "fail", "10000000"}, want: 80},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count := filepath.Join(t.TempDir(), "count")
+			script := `n=$(($(cat '` + count + `' 2>/dev/null || echo 0) + 1)); echo $n > '` + count + `'` + "\ncase $n in\n"
+			for i, rate := range tt.rates {
+				if rate == "fail" {
+					script += fmt.Sprintf("%d) exit 1 ;;\n", i+1)
+				} else {
+					script += fmt.Sprintf("%d) sed 's/12500000/%s/' '%s' ;;\n", i+1, rate, fixture)
+				}
+			}
+			tester := &SpeedTester{Command: fakeCLI(t, script+"esac"), BestOf: len(tt.rates)}
+			metrics := tester.Metrics()
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tester.Latest().Download.GetBandWithInMbps(); got != tt.want {
+				t.Errorf("published %v Mbps, want %v", got, tt.want)
+			}
+			labels := []string{"Acme", "14774", "UNC Chapel Hill", "Chapel Hill, NC", "", MethodOokla}
+			if got := testutil.ToFloat64(metrics.DownloadBandwidth.WithLabelValues(labels...)); got != tt.want {
+				t.Errorf("speedtest_download_speed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnFailure(t *testing.T) {
+	tests := []struct {
+		onFailure string
+		want      int // Series of the result gauges after the failure
+	}{
+		{OnFailureRetain, 1},
+		{OnFailureClear, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.onFailure, func(t *testing.T) {
+			tester := newLoopTester(realClock{})
+			tester.OnFailure = tt.onFailure
+			metrics := tester.Metrics()
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			tester.Simulator.FailureRate = 1
+			if err := tester.Run(); err == nil {
+				t.Fatal("the run succeeded, want a failure")
+			}
+			for name, collector := range map[string]prometheus.Collector{
+				"speedtest_download_speed": metrics.DownloadBandwidth,
+				"speedtest_upload_speed":   metrics.UploadBandwidth,
+				"speedtest_ping_jitter":    metrics.PingJitter,
+			} {
+				if got := testutil.CollectAndCount(collector); got != tt.want {
+					t.Errorf("%s has %d series, want %d", name, got, tt.want)
+				}
+			}
+			if got := testutil.CollectAndCount(metrics.PingLatency); tt.want > 0 && got == 0 || tt.want == 0 && got > 0 {
+				t.Errorf("speedtest_ping_latency has %d series after the failure", got)
+			}
+		})
+	}
+}
+
+func TestOnFailureInterfaces(t *testing.T) {
+	tests := []struct {
+		name string
+		runs int      // Successful runs before the failure
+		want []string // Interfaces of the download series after the failure
+	}{
+		{"first interface", 2, []string{"eth1"}},
+		{"second interface", 3, []string{"eth0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			failing := filepath.Join(t.TempDir(), "failing")
+			cli := fakeCLI(t, `[ -e '`+failing+`' ] && exit 1; cat '`+fixture+`'`)
+			tester := &SpeedTester{Command: cli, Interfaces: []string{"eth0", "eth1"}, OnFailure: OnFailureClear}
+			metrics := tester.Metrics()
+			for range tt.runs {
+				if err := tester.Run(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.WriteFile(failing, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := tester.Run(); err == nil {
+				t.Fatal("the run succeeded, want a failure")
+			}
+			if got := labelValues(t, metrics, "speedtest_download_speed", "interface"); !slices.Equal(got, tt.want) {
+				t.Errorf("speedtest_download_speed has series for %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// labelValues returns the sorted values of the given label on the series of a metric.
+func labelValues(t *testing.T, metrics *PrometheusStats, name, label string) []string {
+	t.Helper()
+	families, err := metrics.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label {
+					values = append(values, l.GetValue())
+				}
+			}
+		}
+	}
+	slices.Sort(values)
+	return values
+}
+
+// recordingSink keeps the results published to it.
+type recordingSink struct {
+	mu      sync.Mutex
+	results []*Stats
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Publish(stats *Stats) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, stats)
+	return nil
+}
+
+func (s *recordingSink) Results() []*Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.results)
+}
+
+func TestRunID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := make(map[string]bool)
+	for range 100 {
+		id := NewRunID()
+		if !uuid.MatchString(id) {
+			t.Fatalf("%q is not a version 4 UUID", id)
+		}
+		if seen[id] {
+			t.Fatalf("duplicate run ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRunIDPropagation(t *testing.T) {
+	sink := new(recordingSink)
+	tester := newLoopTester(realClock{})
+	tester.BestOf = 2
+	tester.Sinks = []Sink{sink}
+	var ids []string
+	for range 2 {
+		if err := tester.Run(); err != nil {
+			t.Fatal(err)
+		}
+		status := tester.Status()
+		if status.LastRunID == "" || status.LastResults.RunID != status.LastRunID {
+			t.Errorf("got lastRunId %q with results of run %q", status.LastRunID, status.LastResults.RunID)
+		}
+		ids = append(ids, status.LastRunID)
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("both runs have the ID %s", ids[0])
+	}
+	results := sink.Results()
+	if len(results) != 2 {
+		t.Fatalf("got %d published results, want 2", len(results))
+	}
+	for i, stats := range results {
+		if stats.RunID != ids[i] {
+			t.Errorf("run %d published results with the ID %q, want %q", i+1, stats.RunID, ids[i])
+		}
+	}
+}
+
+func TestBinaryMtime(t *testing.T) {
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	cli := fixtureCLI(t, "result.json")
+	if err := os.Chtimes(cli, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		command string
+		path    string // PATH to look the command up, when set
+		want    float64
+	}{
+		{name: "absolute path", command: cli, want: float64(mtime.Unix())},
+		{name: "looked up on the PATH", command: "speedtest", path: filepath.Dir(cli), want: float64(mtime.Unix())},
+		{name: "missing", command: filepath.Join(t.TempDir(), "speedtest")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.path != "" {
+				t.Setenv("PATH", tt.path)
+			}
+			tester := &SpeedTester{Command: tt.command}
+			tester.Metrics()
+			tester.updateBinaryMtime(testLogger(t))
+			if got := testutil.ToFloat64(tester.Metrics().BinaryMtime); got != tt.want {
+				t.Errorf("speedtest_binary_mtime_seconds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnMissingServer(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pinned server doesn't exist, but the CLI can select another one.
+	cli := fakeCLI(t, `
+if [ "$4" = "--server-id" ]; then
+  echo '[error] No servers defined (NoServersException)' >&2
+  exit 1
+fi
+cat '`+fixture+`'`)
+	tests := []struct {
+		mode    string
+		want    error
+		retries float64
+	}{
+		{OnMissingServerFail, ErrServerNotFound, 0},
+		{OnMissingServerFallback, nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			tester := &SpeedTester{Command: cli, ServerID: 99999, OnMissingServer: tt.mode}
+			metrics := tester.Metrics()
+			if err := tester.Run(); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			want := 0.0
+			if tt.want != nil {
+				want = 1
+			}
+			if got := testutil.ToFloat64(metrics.FailureReasons.WithLabelValues("server_not_found")); got != want {
+				t.Errorf("speedtest_failures_total{reason=\"server_not_found\"} = %v, want %v", got, want)
+			}
+			if tt.want == nil {
+				if got := testutil.ToFloat64(metrics.Retries); got != tt.retries {
+					t.Errorf("speedtest_run_retries = %v, want %v", got, tt.retries)
+				}
+			}
+		})
+	}
+}
+
+func TestMethodLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, tester *SpeedTester)
+		want  string
+	}{
+		{
+			name: "ookla",
+			setup: func(t *testing.T, tester *SpeedTester) {
+				tester.Command = fixtureCLI(t, "result.json")
+			},
+			want: MethodOokla,
+		},
+		{
+			name: "http fallback",
+			setup: func(t *testing.T, tester *SpeedTester) {
+				server, _ := fallbackServer(t, 1<<16, nil)
+				fallback, err := NewHTTPSpeedTest(server.URL+"/file.bin", time.Minute)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tester.Command = filepath.Join(t.TempDir(), "speedtest")
+				tester.Fallback = fallback
+			},
+			want: MethodFallback,
+		},
+		{
+			name: "simulated",
+			setup: func(t *testing.T, tester *SpeedTester) {
+				tester.Simulator = NewSyntheticRunner()
+				tester.Simulator.FailureRate = 0
+			},
+			want: MethodSimulated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{}
+			tt.setup(t, tester)
+			metrics := tester.Metrics()
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			families, err := metrics.Registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, family := range families {
+				if family.GetName() != "speedtest_download_speed" {
+					continue
+				}
+				for _, metric := range family.GetMetric() {
+					for _, pair := range metric.GetLabel() {
+						if pair.GetName() == "method" {
+							found = true
+							if pair.GetValue() != tt.want {
+								t.Errorf("speedtest_download_speed has method=%q, want %q", pair.GetValue(), tt.want)
+							}
+						}
+					}
+				}
+			}
+			if !found {
+				t.Error("speedtest_download_speed has no method label")
+			}
+		})
+	}
+}
+
+func TestGetBandWithInMbps(t *testing.T) {
+	tests := []struct {
+		bandwidth int64
+		want      float64
+	}{
+		{0, 0},
+		{12500000, 100},
+		{3125000000, 25000}, // Overflows a 32-bit int
+		{1 << 62, float64(1<<62) * 8 / 1e6},
+	}
+	for _, tt := range tests {
+		s := &BandwidthStats{Bandwidth: tt.bandwidth}
+		if got := s.GetBandWithInMbps(); got != tt.want {
+			t.Errorf("GetBandWithInMbps() = %v for %d B/s, want %v", got, tt.bandwidth, tt.want)
+		}
+	}
+}
+
+func TestLargeBandwidth(t *testing.T) {
+	stats := loadResult(t, "result_25gbps.json")
+	if stats.Download.Bandwidth != 3125000000 || stats.Download.Bytes != 37500000000 {
+		t.Errorf("got download %d B/s and %d bytes", stats.Download.Bandwidth, stats.Download.Bytes)
+	}
+	if stats.Upload.Bandwidth != 2500000000 || stats.Upload.Bytes != 25000000000 {
+		t.Errorf("got upload %d B/s and %d bytes", stats.Upload.Bandwidth, stats.Upload.Bytes)
+	}
+
+	tester := &SpeedTester{Command: fixtureCLI(t, "result_25gbps.json")}
+	metrics := tester.Metrics()
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		gauge *prometheus.GaugeVec
+		want  float64
+	}{
+		{"speedtest_download_speed", metrics.DownloadBandwidth, 25000},
+		{"speedtest_upload_speed", metrics.UploadBandwidth, 20000},
+	}
+	for _, tt := range tests {
+		labels := prometheus.Labels{"isp": stats.ISP, "server_id": stats.Server.GetID(), "server_name": stats.Server.Name, "server_location": stats.Server.Location, "interface": "", "method": MethodOokla}
+		if got := testutil.ToFloat64(tt.gauge.With(labels)); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPacketLossHistogram(t *testing.T) {
+	s := &PrometheusStats{}
+	s.Init()
+	for _, loss := range []float64{0, 0, 0.3, 1.5, 7, 60} {
+		s.Update(&Stats{Server: &ServerInfo{ID: 1, Name: "Server"}, ISP: "Acme", Method: MethodOokla, PacketLoss: loss})
+	}
+	// The HTTP fallback doesn't measure the packet loss.
+	s.Update(&Stats{Server: &ServerInfo{ID: 1, Name: "Server"}, ISP: "Acme", Method: MethodFallback})
+
+	const labels = `interface="",isp="Acme",method="ookla",server_id="1",server_location="",server_name="Server"`
+	want := `
+# HELP speedtest_packet_loss_percent The distribution of the Packet Loss in percentage over the runs
+# TYPE speedtest_packet_loss_percent histogram
+speedtest_packet_loss_percent_bucket{` + labels + `,le="0"} 2
+speedtest_packet_loss_percent_bucket{` + labels + `,le="0.5"} 3
+speedtest_packet_loss_percent_bucket{` + labels + `,le="1"} 3
+speedtest_packet_loss_percent_bucket{` + labels + `,le="2"} 4
+speedtest_packet_loss_percent_bucket{` + labels + `,le="5"} 4
+speedtest_packet_loss_percent_bucket{` + labels + `,le="10"} 5
+speedtest_packet_loss_percent_bucket{` + labels + `,le="25"} 5
+speedtest_packet_loss_percent_bucket{` + labels + `,le="50"} 5
+speedtest_packet_loss_percent_bucket{` + labels + `,le="100"} 6
+speedtest_packet_loss_percent_bucket{` + labels + `,le="+Inf"} 6
+speedtest_packet_loss_percent_sum{` + labels + `} 68.8
+speedtest_packet_loss_percent_count{` + labels + `} 6
+`
+	if err := testutil.CollectAndCompare(s.PacketLossDist, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckElapsed(t *testing.T) {
+	tests := []struct {
+		name         string
+		minElapsedMs int64
+		download     int64
+		upload       int64
+		wantErr      string
+	}{
+		{name: "disabled", download: 10, upload: 10},
+		{name: "long enough", minElapsedMs: 1000, download: 12000, upload: 1000},
+		{name: "short download", minElapsedMs: 1000, download: 350, upload: 10000, wantErr: "download took 350 ms"},
+		{name: "short upload", minElapsedMs: 1000, download: 12000, upload: 999, wantErr: "upload took 999 ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{MinElapsedMs: tt.minElapsedMs}
+			stats := &Stats{Download: &BandwidthStats{Elapsed: tt.download}, Upload: &BandwidthStats{Elapsed: tt.upload}}
+			err := tester.checkElapsed(stats)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrTooShort) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %v with %q", err, ErrTooShort, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunTooShort(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    error
+	}{
+		{"result.json", nil},
+		{"result_short.json", ErrTooShort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			tester := &SpeedTester{Command: fixtureCLI(t, tt.fixture), MinElapsedMs: 1000}
+			metrics := tester.Metrics()
+			if err := tester.Run(); !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			published, reason := 1, 0.0
+			if tt.want != nil {
+				published, reason = 0, 1
+			}
+			if got := testutil.CollectAndCount(metrics.DownloadBandwidth); got != published {
+				t.Errorf("got %d download series, want %d", got, published)
+			}
+			if got := testutil.ToFloat64(metrics.FailureReasons.WithLabelValues("too_short")); got != reason {
+				t.Errorf("speedtest_failures_total{reason=\"too_short\"} = %v, want %v", got, reason)
+			}
+		})
+	}
+}
+
+func TestLoggedArgs(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		logLevel string
+		serverID int
+		iface    string
+		wantArgs []string // Expected arguments after the default ones, none when not logged
+	}{
+		{name: "info", logLevel: LogLevelInfo, serverID: 14774},
+		{name: "debug", logLevel: LogLevelDebug, wantArgs: []string{}},
+		{name: "debug with a server", logLevel: LogLevelDebug, serverID: 14774, wantArgs: []string{"--server-id", "14774"}},
+		{name: "debug with an interface", logLevel: LogLevelDebug, serverID: 14774, iface: "eth0", wantArgs: []string{"--server-id", "14774", "--interface", "eth0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "args")
+			tester := &SpeedTester{Command: fakeCLI(t, `printf '%s\n' "$@" > '`+trace+`'; cat '`+fixture+`'`), LogLevel: tt.logLevel, ServerID: tt.serverID}
+			if tt.iface != "" {
+				tester.Interfaces = []string{tt.iface}
+			}
+			tester.Metrics()
+			var logs strings.Builder
+			if _, err := tester.execute(log.New(&logs, "", 0), tt.serverID, tester.NextInterface()); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			if !slices.Equal(args[:len(baseCLIArgs)], baseCLIArgs) {
+				t.Fatalf("got arguments %q, want them to start with %q", args, baseCLIArgs)
+			}
+			logged := regexp.MustCompile(`(?m)^debug: Executing .* with arguments (.*)$`).FindStringSubmatch(logs.String())
+			if tt.wantArgs == nil {
+				if logged != nil {
+					t.Errorf("logged %q at the %s level", logged[0], tt.logLevel)
+				}
+				return
+			}
+			if logged == nil {
+				t.Fatalf("the arguments were not logged:\n%s", logs.String())
+			}
+			if want := fmt.Sprintf("%q", args); logged[1] != want {
+				t.Errorf("logged the arguments %s, but the CLI got %s", logged[1], want)
+			}
+			if got := args[len(baseCLIArgs):]; !slices.Equal(got, tt.wantArgs) {
+				t.Errorf("got the arguments %q after the default ones, want %q", got, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestScrapeDuringRuns(t *testing.T) {
+	tester := &SpeedTester{RunTimestamps: true}
+	metrics := tester.Metrics()
+
+	// Each run reports a download rate 10 times its upload rate, so a scrape mixing
+	// the results of two runs would break the ratio. The runs are published for a
+	// while, as the race detector slows them down.
+	result := loadResult(t, "result.json")
+	var runs int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+			runs++
+			n := runs
+			stats, download, upload := *result, *result.Download, *result.Upload
+			download.Bandwidth, upload.Bandwidth = n*1250000, n*125000
+			stats.Download, stats.Upload = &download, &upload
+			tester.publishResults(&stats)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				checkSnapshot(t, metrics)
+				if stats := tester.Latest(); stats != nil && stats.Download.Bandwidth != 10*stats.Upload.Bandwidth {
+					t.Errorf("got inconsistent results with download %d B/s and upload %d B/s", stats.Download.Bandwidth, stats.Upload.Bandwidth)
+				}
+				tester.StatusHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/status", nil))
+			}
+		}()
+	}
+	wg.Wait()
+	checkSnapshot(t, metrics)
+	if got := tester.Latest().Download.Bandwidth; got != runs*1250000 {
+		t.Errorf("got the results of download %d B/s, want those of the last run", got)
+	}
+}
+
+// checkSnapshot verifies that a scrape sees the download and upload rates of the same run.
+func checkSnapshot(t *testing.T, metrics *PrometheusStats) {
+	t.Helper()
+	families, err := metrics.Gather()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	rates := make(map[string]float64)
+	for _, family := range families {
+		switch name := family.GetName(); name {
+		case "speedtest_download_speed", "speedtest_upload_speed":
+			for _, metric := range family.GetMetric() {
+				rates[name] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+	if math.Abs(rates["speedtest_download_speed"]-10*rates["speedtest_upload_speed"]) > 1e-9 {
+		t.Errorf("a scrape mixed the results of two runs: %v", rates)
+	}
+}
+
+func TestMetricStyles(t *testing.T) {
+	const labels = `interface="",isp="Acme",method="ookla",server_id="14774",server_location="Chapel Hill, NC",server_name="UNC Chapel Hill"`
+	tests := []struct {
+		style   string
+		names   []string // Metrics of the style
+		absent  []string // Metrics of the other style
+		want    string
+		latency string // Metric of the download latency
+	}{
+		{
+			style:   MetricStyleSplit,
+			names:   []string{"speedtest_download_speed", "speedtest_upload_speed", "speedtest_download_jitter", "speedtest_upload_jitter"},
+			absent:  []string{"speedtest_bandwidth_mbps", "speedtest_bandwidth_jitter", "speedtest_bandwidth_latency"},
+			latency: "speedtest_download_latency",
+			want: `
+# HELP speedtest_download_jitter The Download Jitter in milliseconds
+# TYPE speedtest_download_jitter gauge
+speedtest_download_jitter{` + labels + `} 3.4
+# HELP speedtest_download_speed The Download Rate in Mbps
+# TYPE speedtest_download_speed gauge
+speedtest_download_speed{` + labels + `} 100
+# HELP speedtest_upload_jitter The Upload Jitter in milliseconds
+# TYPE speedtest_upload_jitter gauge
+speedtest_upload_jitter{` + labels + `} 4.4
+# HELP speedtest_upload_speed The Upload Rate in Mbps
+# TYPE speedtest_upload_speed gauge
+speedtest_upload_speed{` + labels + `} 20
+`,
+		},
+		{
+			style:   MetricStyleCombined,
+			names:   []string{"speedtest_bandwidth_mbps", "speedtest_bandwidth_jitter"},
+			absent:  []string{"speedtest_download_speed", "speedtest_upload_speed", "speedtest_download_jitter", "speedtest_upload_jitter", "speedtest_download_latency", "speedtest_upload_latency"},
+			latency: "speedtest_bandwidth_latency",
+			want: `
+# HELP speedtest_bandwidth_jitter The Download or Upload Jitter in milliseconds
+# TYPE speedtest_bandwidth_jitter gauge
+speedtest_bandwidth_jitter{direction="download",` + labels + `} 3.4
+speedtest_bandwidth_jitter{direction="upload",` + labels + `} 4.4
+# HELP speedtest_bandwidth_mbps The Download or Upload Rate in Mbps
+# TYPE speedtest_bandwidth_mbps gauge
+speedtest_bandwidth_mbps{direction="download",` + labels + `} 100
+speedtest_bandwidth_mbps{direction="upload",` + labels + `} 20
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			s := &PrometheusStats{MetricStyle: tt.style}
+			s.Init()
+			stats := loadResult(t, "result.json")
+			stats.Method = MethodOokla
+			s.Update(stats)
+			if err := testutil.GatherAndCompare(s.Registry, strings.NewReader(tt.want), tt.names...); err != nil {
+				t.Error(err)
+			}
+			for _, name := range tt.absent {
+				if got, err := testutil.GatherAndCount(s.Registry, name); err != nil || got != 0 {
+					t.Errorf("got %d series of %s: %v", got, name, err)
+				}
+			}
+			if got, err := testutil.GatherAndCount(s.Registry, tt.latency); err != nil || got == 0 {
+				t.Errorf("got %d series of %s: %v", got, tt.latency, err)
+			}
+		})
+	}
+}
+
+func TestServerFar(t *testing.T) {
+	tests := []struct {
+		name          string
+		fixture       string
+		maxDistanceKm float64
+		want          float64 // Expected speedtest_server_far, -1 when not exposed
+	}{
+		{"disabled", "result_far.json", 0, -1},
+		{"near", "result_near.json", 100, 0},
+		{"far", "result_far.json", 100, 1},
+		{"far enough", "result_far.json", 1000, 0},
+		{"unknown distance", "result.json", 100, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs strings.Builder
+			output := log.Writer()
+			log.SetOutput(&logs)
+			defer log.SetOutput(output)
+
+			tester := &SpeedTester{Command: fixtureCLI(t, tt.fixture), MaxDistanceKm: tt.maxDistanceKm}
+			metrics := tester.Metrics()
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if exposed := testutil.CollectAndCount(metrics.ServerFar) > 0; exposed != (tt.want >= 0) {
+				t.Fatalf("exposed speedtest_server_far: %t, want %t", exposed, tt.want >= 0)
+			}
+			if tt.want >= 0 {
+				if got := testutil.ToFloat64(metrics.ServerFar.WithLabelValues(metrics.labelValues(tester.Latest())...)); got != tt.want {
+					t.Errorf("speedtest_server_far = %v, want %v", got, tt.want)
+				}
+			}
+			if warned := strings.Contains(logs.String(), "consider pinning a closer server"); warned != (tt.want == 1) {
+				t.Errorf("logged a warning: %t, want %t", warned, tt.want == 1)
+			}
+		})
+	}
+}
+
+func TestCLIOutputBytes(t *testing.T) {
+	info, err := os.Stat(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cli     func(*testing.T) string
+		want    float64
+		wantErr error
+	}{
+		{name: "fixture", cli: func(t *testing.T) string { return fixtureCLI(t, "result.json") }, want: float64(info.Size())},
+		{name: "truncated", cli: func(t *testing.T) string { return fakeCLI(t, `printf '{"type":"result",'`) }, want: 17, wantErr: ErrParse},
+		{name: "no output", cli: func(t *testing.T) string { return fakeCLI(t, "exit 1") }, want: 0, wantErr: ErrExec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{Command: tt.cli(t)}
+			metrics := tester.Metrics()
+			metrics.CLIOutputBytes.Set(-1) // Set on each run, even when the output is invalid
+			if err := tester.Run(); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := testutil.ToFloat64(metrics.CLIOutputBytes); got != tt.want {
+				t.Errorf("speedtest_cli_output_bytes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	tests := []struct {
+		name        string
+		cliDuration time.Duration
+		download    int64 // Elapsed ms, if any
+		upload      int64
+		want        map[string]int64
+	}{
+		{name: "all phases", cliDuration: 25 * time.Second, download: 12000, upload: 10000, want: map[string]int64{"ping": 3000, "download": 12000, "upload": 10000}},
+		{name: "without the CLI duration", download: 12000, upload: 10000, want: map[string]int64{"download": 12000, "upload": 10000}},
+		{name: "no upload", cliDuration: 15 * time.Second, download: 12000, want: map[string]int64{"ping": 3000, "download": 12000}},
+		{name: "CLI duration shorter than the phases", cliDuration: 20 * time.Second, download: 12000, upload: 10000, want: map[string]int64{"download": 12000, "upload": 10000}},
+		{name: "none", want: map[string]int64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &Stats{CLIDuration: tt.cliDuration}
+			if tt.download > 0 {
+				stats.Download = &BandwidthStats{Elapsed: tt.download}
+			}
+			if tt.upload > 0 {
+				stats.Upload = &BandwidthStats{Elapsed: tt.upload}
+			}
+			if got := stats.PhaseDurations(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPhaseDurationMetric(t *testing.T) {
+	phase := func(name string) string {
+		return `interface="",isp="Acme",method="ookla",phase="` + name + `",server_id="14774",server_location="Chapel Hill, NC",server_name="UNC Chapel Hill"`
+	}
+	s := &PrometheusStats{}
+	s.Init()
+	stats := loadResult(t, "result.json")
+	stats.Method = MethodOokla
+	stats.CLIDuration = 24500 * time.Millisecond
+	s.Update(stats)
+	want := `
+# HELP speedtest_phase_duration_ms The duration in milliseconds of each phase of the test (ping, download, upload); ping includes the server selection
+# TYPE speedtest_phase_duration_ms gauge
+speedtest_phase_duration_ms{` + phase("download") + `} 12000
+speedtest_phase_duration_ms{` + phase("ping") + `} 2500
+speedtest_phase_duration_ms{` + phase("upload") + `} 10000
+`
+	if err := testutil.CollectAndCompare(s.PhaseDuration, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
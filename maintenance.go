@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a period during which the scheduled runs are paused. It is
+// either recurring, as [day[-day]] HH:MM-HH:MM in local time (e.g. "Sun 02:00-04:00",
+// "Mon-Fri 23:00-01:00", or "03:00-03:30" for every day), or absolute, as two RFC 3339
+// times separated by a slash (e.g. "2024-05-01T22:00:00Z/2024-05-02T02:00:00Z").
+type MaintenanceWindow struct {
+	days       [7]bool       // Weekdays on which a recurring window starts
+	start, end time.Duration // Offsets since midnight of a recurring window; crosses midnight when end <= start
+	from, to   time.Time     // Bounds of an absolute window
+}
+
+// ParseMaintenanceWindow parses a window in one of the supported formats.
+func ParseMaintenanceWindow(value string) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	if from, to, ok := strings.Cut(value, "/"); ok {
+		var err error
+		if w.from, err = time.Parse(time.RFC3339, from); err != nil {
+			return w, err
+		}
+		if w.to, err = time.Parse(time.RFC3339, to); err != nil {
+			return w, err
+		}
+		if !w.to.After(w.from) {
+			return w, fmt.Errorf("the end of %q must be after its start", value)
+		}
+		return w, nil
+	}
+
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 1:
+		for i := range w.days {
+			w.days[i] = true
+		}
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, err
+		}
+		fields = fields[1:]
+	default:
+		return w, fmt.Errorf("expected [day[-day]] HH:MM-HH:MM or start/end, got %q", value)
+	}
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("expected HH:MM-HH:MM, got %q", fields[0])
+	}
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+// parseDays parses a weekday or a range of weekdays, like Sat-Sun.
+func (w *MaintenanceWindow) parseDays(value string) error {
+	first, last, isRange := strings.Cut(value, "-")
+	if !isRange {
+		last = first
+	}
+	i, j := weekday(first), weekday(last)
+	if i < 0 || j < 0 {
+		return fmt.Errorf("invalid weekday in %q, expected names like Mon or Monday", value)
+	}
+	for d := i; ; d = (d + 1) % 7 {
+		w.days[d] = true
+		if d == j {
+			return nil
+		}
+	}
+}
+
+// weekday returns the number of the weekday by its name or abbreviation, or -1 when unknown.
+func weekday(name string) int {
+	for i := range 7 {
+		full := time.Weekday(i).String()
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseClock parses a time of the day as HH:MM, returning the offset since midnight.
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains returns whether the given time is within the window.
+func (w MaintenanceWindow) Contains(now time.Time) bool {
+	if !w.from.IsZero() {
+		return !now.Before(w.from) && now.Before(w.to)
+	}
+	day := int(now.Weekday())
+	offset := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute + time.Duration(now.Second())*time.Second
+	if w.start < w.end {
+		return w.days[day] && offset >= w.start && offset < w.end
+	}
+	// Crosses midnight, so it may have started the previous day.
+	return (w.days[day] && offset >= w.start) || (w.days[(day+6)%7] && offset < w.end)
+}
+
+// inMaintenance returns whether the given time is within any of the maintenance windows.
+func (t *SpeedTester) inMaintenance(now time.Time) bool {
+	for _, w := range t.Maintenance {
+		if w.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr bool
+	}{
+		{value: "03:00-03:30"},
+		{value: "Sun 02:00-04:00"},
+		{value: "sunday 02:00-04:00"},
+		{value: "Mon-Fri 23:00-01:00"},
+		{value: "Fri-Mon 00:00-06:00"},
+		{value: "2024-05-01T22:00:00Z/2024-05-02T02:00:00Z"},
+		{value: "2024-05-02T02:00:00Z/2024-05-01T22:00:00Z", wantErr: true},
+		{value: "2024-05-01/2024-05-02", wantErr: true},
+		{value: "Funday 02:00-04:00", wantErr: true},
+		{value: "Sun 02:00", wantErr: true},
+		{value: "Sun 2am-4am", wantErr: true},
+		{value: "25:00-26:00", wantErr: true},
+		{value: "Sun Mon 02:00-04:00", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if _, err := ParseMaintenanceWindow(tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2024-06-01 is a Saturday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 6, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		window string
+		now    time.Time
+		want   bool
+	}{
+		{"03:00-03:30", at(1, 3, 0), true},
+		{"03:00-03:30", at(1, 3, 29), true},
+		{"03:00-03:30", at(1, 3, 30), false},
+		{"03:00-03:30", at(1, 2, 59), false},
+		{"Sun 02:00-04:00", at(2, 3, 0), true},
+		{"Sun 02:00-04:00", at(1, 3, 0), false},
+		{"Sat-Sun 02:00-04:00", at(1, 3, 0), true},
+		{"Fri-Mon 02:00-04:00", at(4, 3, 0), false}, // Tuesday
+		// Crossing midnight, the window continues the next day.
+		{"Mon-Fri 23:00-01:00", at(3, 23, 30), true}, // Monday
+		{"Mon-Fri 23:00-01:00", at(4, 0, 30), true},  // Tuesday, after Monday night
+		{"Mon-Fri 23:00-01:00", at(1, 0, 30), true},  // Saturday, after Friday night
+		{"Mon-Fri 23:00-01:00", at(2, 0, 30), false}, // Sunday, after Saturday night
+		{"Mon-Fri 23:00-01:00", at(1, 23, 30), false},
+		{"Mon-Fri 23:00-01:00", at(3, 1, 0), false},
+		{"2024-06-01T10:10:00Z/2024-06-01T10:20:00Z", at(1, 10, 10), true},
+		{"2024-06-01T10:10:00Z/2024-06-01T10:20:00Z", at(1, 10, 20), false},
+		{"2024-06-01T10:10:00Z/2024-06-01T10:20:00Z", at(1, 10, 9), false},
+		{"2024-06-01T12:10:00+02:00/2024-06-01T12:20:00+02:00", at(1, 10, 15), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window+" "+tt.now.Format("Mon 15:04"), func(t *testing.T) {
+			w, err := ParseMaintenanceWindow(tt.window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Contains(tt.now); got != tt.want {
+				t.Errorf("Contains(%s) = %t, want %t", tt.now.Format(time.RFC1123), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMaintenance(t *testing.T) {
+	var windows []MaintenanceWindow
+	for _, value := range []string{"Sun 02:00-04:00", "2024-06-01T10:10:00Z/2024-06-01T10:20:00Z"} {
+		w, err := ParseMaintenanceWindow(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		windows = append(windows, w)
+	}
+	tests := []struct {
+		windows []MaintenanceWindow
+		now     time.Time
+		want    bool
+	}{
+		{nil, time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC), false},
+		{windows, time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC), true},
+		{windows, time.Date(2024, 6, 1, 10, 15, 0, 0, time.UTC), true},
+		{windows, time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		tester := &SpeedTester{Maintenance: tt.windows}
+		if got := tester.inMaintenance(tt.now); got != tt.want {
+			t.Errorf("inMaintenance(%s) with %d windows = %t, want %t", tt.now, len(tt.windows), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Additional CLI options that are known to be safe to pass through, as they don't
+// change the output format or the behavior the tool relies on.
+var allowedCLIOptions = []string{
+	"ca-certificate", // CA Certificate bundle path used for the TLS connections
+}
+
+// CLIOption is an additional option passed through to the speedtest CLI.
+type CLIOption struct {
+	Name  string
+	Value string
+}
+
+// Arg returns the option formatted as a CLI argument.
+func (o CLIOption) Arg() string {
+	return fmt.Sprintf("--%s=%s", o.Name, o.Value)
+}
+
+// ParseCLIOptions parses a list of name=value entries, rejecting options that are not allowed.
+func ParseCLIOptions(entries []string) ([]CLIOption, error) {
+	var options []CLIOption
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(strings.TrimLeft(entry, "-"), "=")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected name=value", entry)
+		}
+		if !slices.Contains(allowedCLIOptions, name) {
+			return nil, fmt.Errorf("option %q is not allowed, expected one of %s", name, strings.Join(allowedCLIOptions, ", "))
+		}
+		if slices.ContainsFunc(options, func(o CLIOption) bool { return o.Name == name }) {
+			return nil, fmt.Errorf("duplicate option %q", name)
+		}
+		options = append(options, CLIOption{Name: name, Value: value})
+	}
+	return options, nil
+}
+
+// ParseProxyURL validates the URL of the proxy used by the speedtest CLI.
+func ParseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing proxy host")
+	}
+	return u, nil
+}
+
+// proxyEnv returns the standard proxy environment variables for the speedtest CLI,
+// which doesn't have a dedicated option for it.
+func proxyEnv(proxy *url.URL) []string {
+	var env []string
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "ALL_PROXY"} {
+		env = append(env, name+"="+proxy.String(), strings.ToLower(name)+"="+proxy.String())
+	}
+	return env
+}
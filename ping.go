@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Number of connections used to measure the latency with the built-in pinger.
+const tcpPings = 3
+
+// tcpPing measures the latency as the time to establish TCP connections to the
+// given host:port, which doesn't require privileges unlike ICMP. When iface is an
+// IP address, the connections are bound to it.
+func tcpPing(ctx context.Context, address, iface string, count int) (*PingStats, error) {
+	dialer := &net.Dialer{}
+	if ip := net.ParseIP(iface); ip != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	var samples []float64
+	for range count {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, float64(time.Since(start).Microseconds())/1000)
+		conn.Close()
+	}
+	return pingStats(samples), nil
+}
+
+// pingTarget returns the host:port to measure the idle latency before a test, and
+// the server to describe the results; either the configured target or the server
+// of the last results. Returns an empty address when neither is known.
+func (t *SpeedTester) pingTarget() (string, *ServerInfo) {
+	if t.PingTarget != "" {
+		host, _, _ := net.SplitHostPort(t.PingTarget)
+		return t.PingTarget, &ServerInfo{ID: t.ServerID, Host: host, Name: host}
+	}
+	if s := t.lastServer; s != nil && s.Host != "" && s.Port > 0 {
+		return net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), s
+	}
+	return "", nil
+}
+
+// precheck measures the idle latency and, when it exceeds the threshold, returns
+// the ping results and ErrSkippedSlow, so the throughput test doesn't waste time
+// and data on a degraded link. Problems measuring the latency don't prevent the test.
+func (t *SpeedTester) precheck(logger *log.Logger, iface string) (*Stats, error) {
+	address, server := t.pingTarget()
+	if address == "" {
+		logger.Println("Skipping the latency pre-check, the server is not known yet")
+		return nil, nil
+	}
+	timeout := time.Duration(t.MaxPingMs*float64(time.Millisecond))*tcpPings + 5*time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ping, err := tcpPing(ctx, address, iface, tcpPings)
+	if err != nil {
+		logger.Printf("latency pre-check to %s failed, running the test anyway: %v", address, err)
+		return nil, nil
+	}
+	if ping.Latency <= t.MaxPingMs {
+		return nil, nil
+	}
+	stats := &Stats{Server: server, Ping: ping, Source: iface, Method: MethodTCPPing}
+	return stats, fmt.Errorf("%w: %.2f ms to %s, expected at most %.2f ms", ErrSkippedSlow, ping.Latency, address, t.MaxPingMs)
+}
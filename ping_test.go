@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// pingListener accepts TCP connections until the end of the test, returning its address.
+func pingListener(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestTCPPing(t *testing.T) {
+	address := pingListener(t)
+	ping, err := tcpPing(context.Background(), address, "127.0.0.1", tcpPings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ping.Latency <= 0 || ping.Low > ping.Latency || ping.High < ping.Latency {
+		t.Errorf("got inconsistent ping results %+v", ping)
+	}
+	if _, err := tcpPing(context.Background(), "127.0.0.1:1", "", tcpPings); err == nil {
+		t.Error("got no error for a closed port")
+	}
+}
+
+func TestPingTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		lastServer  *ServerInfo
+		wantAddress string
+		wantServer  string
+	}{
+		{name: "unknown"},
+		{name: "configured", target: "ping.example.com:443", lastServer: &ServerInfo{Host: "other.example.com", Port: 8080}, wantAddress: "ping.example.com:443", wantServer: "ping.example.com"},
+		{name: "last server", lastServer: &ServerInfo{Host: "speed.example.com", Port: 8080, Name: "Speed"}, wantAddress: "speed.example.com:8080", wantServer: "Speed"},
+		{name: "last server without port", lastServer: &ServerInfo{Host: "speed.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{PingTarget: tt.target, lastServer: tt.lastServer}
+			address, server := tester.pingTarget()
+			if address != tt.wantAddress {
+				t.Errorf("got address %q, want %q", address, tt.wantAddress)
+			}
+			name := ""
+			if server != nil {
+				name = server.Name
+			}
+			if name != tt.wantServer {
+				t.Errorf("got server %q, want %q", name, tt.wantServer)
+			}
+		})
+	}
+}
+
+func TestRunSkipSlow(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := pingListener(t)
+	tests := []struct {
+		name      string
+		target    string
+		maxPingMs float64
+		wantSkip  bool
+	}{
+		// Even the loopback takes longer than a nanosecond to connect.
+		{name: "slow", target: address, maxPingMs: 1e-6, wantSkip: true},
+		{name: "fast", target: address, maxPingMs: 1000},
+		{name: "unknown server", maxPingMs: 1e-6},
+		{name: "unreachable target", target: "127.0.0.1:1", maxPingMs: 1e-6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "trace")
+			cli := fakeCLI(t, `touch '`+trace+`'; cat '`+fixture+`'`)
+			tester := &SpeedTester{Command: cli, MaxPingMs: tt.maxPingMs, PingTarget: tt.target, OnFailure: OnFailureClear}
+			metrics := tester.Metrics()
+			err := tester.Run()
+			_, statErr := os.Stat(trace)
+			if ran := statErr == nil; ran == tt.wantSkip {
+				t.Errorf("ran the CLI: %t, want %t", ran, !tt.wantSkip)
+			}
+			if !tt.wantSkip {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrSkippedSlow) {
+				t.Fatalf("got error %v, want %v", err, ErrSkippedSlow)
+			}
+			if got := testutil.ToFloat64(metrics.FailureReasons.WithLabelValues("skipped_slow")); got != 1 {
+				t.Errorf("speedtest_failures_total{reason=\"skipped_slow\"} = %v, want 1", got)
+			}
+			// Only the ping results are published, and not cleared despite the failure.
+			if got := testutil.CollectAndCount(metrics.PingLatency); got == 0 {
+				t.Error("the ping results were not published")
+			}
+			if got := testutil.CollectAndCount(metrics.DownloadBandwidth); got != 0 {
+				t.Errorf("got %d download series, want none", got)
+			}
+			host, _, _ := net.SplitHostPort(address)
+			labels := []string{"", "0", host, "", "", MethodTCPPing, "idle"}
+			if got := testutil.ToFloat64(metrics.PingLatency.WithLabelValues(labels...)); got <= tt.maxPingMs {
+				t.Errorf("speedtest_ping_latency = %v, want above %v", got, tt.maxPingMs)
+			}
+		})
+	}
+}
+
+func TestRunSkipSlowLastServer(t *testing.T) {
+	address := pingListener(t)
+	host, port, _ := net.SplitHostPort(address)
+	stats := loadResult(t, "result.json")
+	stats.Server.Host = host
+	stats.Server.Port, _ = strconv.Atoi(port)
+	data, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := filepath.Join(t.TempDir(), "result.json")
+	if err := os.WriteFile(fixture, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first run finds the server, and the second one measures its latency.
+	tester := &SpeedTester{Command: fakeCLI(t, `cat '`+fixture+`'`), MaxPingMs: 1e-6}
+	tester.Metrics()
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.Run(); !errors.Is(err, ErrSkippedSlow) {
+		t.Errorf("got error %v, want %v", err, ErrSkippedSlow)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !postgres
+
+package main
+
+import "fmt"
+
+// NewPostgresSink is only available when building with the postgres tag.
+func NewPostgresSink(dsn, table string, createTable bool) (Sink, error) {
+	return nil, fmt.Errorf("built without PostgreSQL support (build with -tags postgres)")
+}
@@ -0,0 +1,280 @@
+//go:build postgres
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePostgres is an in-memory database recording the statements of the sink, which
+// can be made unavailable to simulate a restart.
+type fakePostgres struct {
+	mu       sync.Mutex
+	down     bool
+	executed []string         // Statements executed without preparing them
+	prepared []string         // Statements prepared
+	rows     [][]driver.Value // Arguments of the executions of the prepared statements
+}
+
+func (db *fakePostgres) Connect(context.Context) (driver.Conn, error) {
+	if db.isDown() {
+		return nil, errors.New("connection refused")
+	}
+	return &fakePostgresConn{db: db}, nil
+}
+
+func (db *fakePostgres) Driver() driver.Driver {
+	return nil
+}
+
+func (db *fakePostgres) isDown() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.down
+}
+
+func (db *fakePostgres) SetDown(down bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.down = down
+}
+
+func (db *fakePostgres) Rows() [][]driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([][]driver.Value(nil), db.rows...)
+}
+
+type fakePostgresConn struct {
+	db *fakePostgres
+}
+
+func (c *fakePostgresConn) Prepare(query string) (driver.Stmt, error) {
+	if c.db.isDown() {
+		return nil, driver.ErrBadConn
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.prepared = append(c.db.prepared, query)
+	return &fakePostgresStmt{db: c.db}, nil
+}
+
+func (c *fakePostgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.db.isDown() {
+		return nil, driver.ErrBadConn
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.executed = append(c.db.executed, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakePostgresConn) Close() error {
+	return nil
+}
+
+func (c *fakePostgresConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakePostgresStmt struct {
+	db *fakePostgres
+}
+
+func (s *fakePostgresStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.db.isDown() {
+		return nil, errors.New("the database system is shutting down")
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.rows = append(s.db.rows, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakePostgresStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+func (s *fakePostgresStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakePostgresStmt) Close() error {
+	return nil
+}
+
+// newFakePostgresSink returns a sink inserting into a fake database.
+func newFakePostgresSink(t *testing.T, createTable bool) (*PostgresSink, *fakePostgres) {
+	t.Helper()
+	fake := new(fakePostgres)
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+	return &PostgresSink{Table: "speedtest.results", CreateTable: createTable, Timeout: time.Second, db: db}, fake
+}
+
+func TestNewPostgresSink(t *testing.T) {
+	for _, tt := range []struct {
+		table   string
+		wantErr bool
+	}{
+		{table: "speedtest_results"},
+		{table: "metrics.speedtest_results"},
+		{table: "results; DROP TABLE users", wantErr: true},
+		{table: "a.b.c", wantErr: true},
+		{table: "1results", wantErr: true},
+	} {
+		// The connection is only established on the first publish.
+		if _, err := NewPostgresSink("postgres://user@127.0.0.1:1/db", tt.table, true); (err != nil) != tt.wantErr {
+			t.Errorf("NewPostgresSink(%q) got error %v, want an error: %t", tt.table, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPostgresSinkPublish(t *testing.T) {
+	tests := []struct {
+		createTable bool
+		wantCreate  int // Expected CREATE TABLE statements
+	}{
+		{createTable: true, wantCreate: 1},
+		{createTable: false},
+	}
+	for _, tt := range tests {
+		t.Run("create table "+strconv.FormatBool(tt.createTable), func(t *testing.T) {
+			sink, fake := newFakePostgresSink(t, tt.createTable)
+			stats := loadResult(t, "result.json")
+			stats.RunID = "run-1"
+			stats.Method = MethodOokla
+			for range 2 {
+				if err := sink.Publish(stats); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := len(fake.executed); got != tt.wantCreate {
+				t.Errorf("executed %v, want %d CREATE TABLE", fake.executed, tt.wantCreate)
+			}
+			for _, query := range fake.executed {
+				if !strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS speedtest.results (") {
+					t.Errorf("executed %q, want the creation of the table", query)
+				}
+			}
+			// The statement is prepared once for all the runs.
+			if len(fake.prepared) != 1 || !strings.HasPrefix(fake.prepared[0], "INSERT INTO speedtest.results (time, run_id,") {
+				t.Errorf("prepared %v, want a single insert", fake.prepared)
+			}
+
+			rows := fake.Rows()
+			if len(rows) != 2 {
+				t.Fatalf("inserted %d rows, want 2", len(rows))
+			}
+			row := rows[0]
+			if len(row) != 12 {
+				t.Fatalf("inserted %d columns, want 12", len(row))
+			}
+			if got := row[0].(time.Time); !got.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("time = %s, want the one of the results", got)
+			}
+			want := []driver.Value{"run-1", "14774", "UNC Chapel Hill", "Acme", MethodOokla, 100.0, 20.0, 10.5, 1.2, 0.0}
+			for i, w := range want {
+				if row[i+1] != w {
+					t.Errorf("column %d = %v, want %v", i+2, row[i+1], w)
+				}
+			}
+			var metrics map[string]float64
+			if err := json.Unmarshal(row[11].([]byte), &metrics); err != nil {
+				t.Fatal(err)
+			}
+			if metrics["download_latency_ms"] != 20.1 {
+				t.Errorf("got the metrics %v, want all the values of the results", metrics)
+			}
+		})
+	}
+}
+
+func TestPostgresSinkMissingValues(t *testing.T) {
+	sink, fake := newFakePostgresSink(t, false)
+	// The packet loss is not measured by the HTTP fallback.
+	stats := loadResult(t, "result.json")
+	stats.Method = MethodFallback
+	stats.Upload = nil
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	row := fake.Rows()[0]
+	if row[7] != nil || row[10] != nil {
+		t.Errorf("upload_mbps = %v and packet_loss_percent = %v, want NULL", row[7], row[10])
+	}
+}
+
+func TestPostgresSinkUnavailable(t *testing.T) {
+	sink, fake := newFakePostgresSink(t, true)
+	publish := func(id string) error {
+		stats := loadResult(t, "result.json")
+		stats.RunID = id
+		return sink.Publish(stats)
+	}
+
+	// Unavailable on start, the rows are kept.
+	fake.SetDown(true)
+	for _, id := range []string{"run-1", "run-2"} {
+		if err := publish(id); err == nil || !strings.Contains(err.Error(), "rows pending") {
+			t.Fatalf("got error %v publishing %s, want the rows pending", err, id)
+		}
+	}
+	fake.SetDown(false)
+	if err := publish("run-3"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unavailable after preparing the insert, which is prepared again.
+	fake.SetDown(true)
+	if err := publish("run-4"); err == nil {
+		t.Fatal("got no error while the database is unavailable")
+	}
+	fake.SetDown(false)
+	if err := publish("run-5"); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, row := range fake.Rows() {
+		ids = append(ids, row[1].(string))
+	}
+	if got := strings.Join(ids, ","); got != "run-1,run-2,run-3,run-4,run-5" {
+		t.Errorf("inserted the runs %s, want all of them in order", got)
+	}
+	if len(fake.prepared) != 2 {
+		t.Errorf("prepared the insert %d times, want 2", len(fake.prepared))
+	}
+}
+
+func TestPostgresSinkMaxPending(t *testing.T) {
+	sink, fake := newFakePostgresSink(t, false)
+	fake.SetDown(true)
+	stats := loadResult(t, "result.json")
+	for i := range postgresMaxPending + 5 {
+		stats.RunID = strconv.Itoa(i + 1)
+		sink.Publish(stats)
+	}
+	fake.SetDown(false)
+	stats.RunID = "last"
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	rows := fake.Rows()
+	if len(rows) != postgresMaxPending {
+		t.Fatalf("inserted %d rows, want %d", len(rows), postgresMaxPending)
+	}
+	// The oldest rows are dropped first.
+	if first, last := rows[0][1], rows[len(rows)-1][1]; first != "7" || last != "last" {
+		t.Errorf("inserted the runs %v to %v, want 7 to last", first, last)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteSink pushes the gathered metrics to a Prometheus remote-write endpoint
+// (e.g. Grafana Cloud or Mimir) after each run, for setups without a local scraper.
+type RemoteWriteSink struct {
+	URL       string
+	Headers   map[string]string // Additional headers, e.g. Authorization or X-Scope-OrgID
+	Username  string            // Basic authentication, when set
+	Password  string
+	Labels    map[string]string // Added to every series, like the job and instance labels of a scrape
+	Gatherer  prometheus.Gatherer
+	Client    *http.Client
+	timestamp func() time.Time
+}
+
+// NewRemoteWriteSink creates a sink pushing the metrics of the gatherer to the given
+// http or https URL. Headers are given as name=value.
+func NewRemoteWriteSink(rawURL string, gatherer prometheus.Gatherer, headers []string, username, password string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL")
+	}
+	s := &RemoteWriteSink{
+		URL:       u.String(),
+		Headers:   make(map[string]string),
+		Username:  username,
+		Password:  password,
+		Labels:    map[string]string{"job": "speedtester"},
+		Gatherer:  gatherer,
+		Client:    newHTTPClient(30 * time.Second),
+		timestamp: time.Now,
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected name=value", h)
+		}
+		s.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		s.Labels["instance"] = hostname
+	}
+	return s, nil
+}
+
+func (s *RemoteWriteSink) Name() string {
+	return "remote-write"
+}
+
+// Publish pushes all the gathered metrics, not only the results of the run, like a scrape would.
+func (s *RemoteWriteSink) Publish(stats *Stats) error {
+	families, err := s.Gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("cannot gather metrics: %w", err)
+	}
+	body := snappy.Encode(nil, s.encode(families))
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// encode builds a remote-write WriteRequest protobuf message with one series per
+// sample, expanding histograms and summaries like the text exposition format.
+func (s *RemoteWriteSink) encode(families []*dto.MetricFamily) []byte {
+	now := s.timestamp().UnixMilli()
+	var req []byte
+	var ts int64
+	series := func(name string, labels []*dto.LabelPair, value float64, extra ...string) {
+		all := map[string]string{"__name__": name}
+		for k, v := range s.Labels {
+			all[k] = v
+		}
+		for _, l := range labels {
+			all[l.GetName()] = l.GetValue()
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			all[extra[i]] = extra[i+1]
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, encodeTimeSeries(all, value, ts))
+	}
+	for _, f := range families {
+		name := f.GetName()
+		for _, m := range f.GetMetric() {
+			labels := m.GetLabel()
+			// The samples with the time of the run keep it, the rest get the time of the push.
+			ts = now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			switch f.GetType() {
+			case dto.MetricType_COUNTER:
+				series(name, labels, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				series(name, labels, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				series(name, labels, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					series(name+"_bucket", labels, float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				series(name+"_bucket", labels, float64(h.GetSampleCount()), "le", "+Inf")
+				series(name+"_sum", labels, h.GetSampleSum())
+				series(name+"_count", labels, float64(h.GetSampleCount()))
+			case dto.MetricType_SUMMARY:
+				sum := m.GetSummary()
+				for _, q := range sum.GetQuantile() {
+					series(name, labels, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				series(name+"_sum", labels, sum.GetSampleSum())
+				series(name+"_count", labels, float64(sum.GetSampleCount()))
+			}
+		}
+	}
+	return req
+}
+
+// encodeTimeSeries encodes a TimeSeries message with the labels sorted by name and a single sample.
+func encodeTimeSeries(labels map[string]string, value float64, ts int64) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b []byte
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, labels[name])
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ts))
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, sample)
+}
+
+// formatFloat formats the bucket bounds and quantiles like the text exposition format.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
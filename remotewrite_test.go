@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestNewRemoteWriteSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		headers []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "valid", url: "https://prometheus.example.com/api/v1/write", headers: []string{"X-Scope-OrgID = tenant", "Authorization=Bearer a=b"}, want: map[string]string{"X-Scope-OrgID": "tenant", "Authorization": "Bearer a=b"}},
+		{name: "invalid scheme", url: "ftp://prometheus.example.com/write", wantErr: true},
+		{name: "missing host", url: "/api/v1/write", wantErr: true},
+		{name: "invalid header", url: "https://prometheus.example.com/api/v1/write", headers: []string{"X-Scope-OrgID"}, wantErr: true},
+		{name: "empty header name", url: "https://prometheus.example.com/api/v1/write", headers: []string{" =tenant"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := NewRemoteWriteSink(tt.url, prometheus.NewRegistry(), tt.headers, "", "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			s := sink.(*RemoteWriteSink)
+			if fmt.Sprint(s.Headers) != fmt.Sprint(tt.want) {
+				t.Errorf("got headers %v, want %v", s.Headers, tt.want)
+			}
+			if s.Labels["job"] != "speedtester" {
+				t.Errorf("got labels %v, want the speedtester job", s.Labels)
+			}
+		})
+	}
+}
+
+// remoteWriteReceiver is a fake remote-write endpoint, decoding the series it receives
+// as "name{label="value",...} value @timestamp" lines.
+type remoteWriteReceiver struct {
+	mu      sync.Mutex
+	header  http.Header
+	series  []string
+	status  int
+	message string
+}
+
+func newRemoteWriteReceiver(t *testing.T) (*remoteWriteReceiver, string) {
+	t.Helper()
+	r := &remoteWriteReceiver{status: http.StatusNoContent}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.header = req.Header.Clone()
+		body, _ := io.ReadAll(req.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.series, err = decodeWriteRequest(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.status != http.StatusNoContent {
+			http.Error(w, r.message, r.status)
+			return
+		}
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+// decodeWriteRequest decodes the TimeSeries of a WriteRequest message.
+func decodeWriteRequest(data []byte) ([]string, error) {
+	var series []string
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || num != 1 || typ != protowire.BytesType {
+			return nil, fmt.Errorf("unexpected field %d of type %d on the write request", num, typ)
+		}
+		data = data[n:]
+		ts, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+		s, err := decodeTimeSeries(ts)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	sort.Strings(series)
+	return series, nil
+}
+
+func decodeTimeSeries(data []byte) (string, error) {
+	var name string
+	var labels []string
+	var sample string
+	for len(data) > 0 {
+		num, _, n := protowire.ConsumeTag(data)
+		data = data[n:]
+		msg, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return "", protowire.ParseError(n)
+		}
+		data = data[n:]
+		fields := make(map[protowire.Number][]byte)
+		for len(msg) > 0 {
+			field, typ, n := protowire.ConsumeTag(msg)
+			msg = msg[n:]
+			n = protowire.ConsumeFieldValue(field, typ, msg)
+			fields[field] = msg[:n]
+			msg = msg[n:]
+		}
+		switch num {
+		case 1: // Label
+			labelName, _ := protowire.ConsumeString(fields[1])
+			value, _ := protowire.ConsumeString(fields[2])
+			if labelName == "__name__" {
+				name = value
+			} else {
+				labels = append(labels, fmt.Sprintf("%s=%q", labelName, value))
+			}
+		case 2: // Sample
+			value, _ := protowire.ConsumeFixed64(fields[1])
+			ts, _ := protowire.ConsumeVarint(fields[2])
+			sample = fmt.Sprintf("%g @%d", math.Float64frombits(value), ts)
+		}
+	}
+	if !slices.IsSorted(labels) {
+		return "", fmt.Errorf("the labels of %s are not sorted: %v", name, labels)
+	}
+	return fmt.Sprintf("%s{%s} %s", name, strings.Join(labels, ","), sample), nil
+}
+
+// timestampedGauge is a gauge with the value 1 at the given time, like the results with --run-timestamps.
+type timestampedGauge struct {
+	desc *prometheus.Desc
+	at   time.Time
+}
+
+func (g *timestampedGauge) Describe(ch chan<- *prometheus.Desc) {
+	ch <- g.desc
+}
+
+func (g *timestampedGauge) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.NewMetricWithTimestamp(g.at, prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, 1))
+}
+
+func TestRemoteWriteSinkPublish(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "Test"}, []string{"status"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_gauge", Help: "Test"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "Test", Buckets: []float64{0.5, 1}})
+	registry.MustRegister(counter, gauge, histogram)
+	counter.WithLabelValues("ok").Add(3)
+	gauge.Set(95.5)
+	histogram.Observe(0.7)
+	run := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	registry.MustRegister(&timestampedGauge{desc: prometheus.NewDesc("test_result", "Test", nil, nil), at: run})
+
+	receiver, url := newRemoteWriteReceiver(t)
+	sink, err := NewRemoteWriteSink(url, registry, []string{"X-Scope-OrgID=tenant"}, "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := sink.(*RemoteWriteSink)
+	now := time.Date(2024, 6, 1, 10, 1, 0, 0, time.UTC)
+	s.timestamp = func() time.Time { return now }
+	s.Labels = map[string]string{"job": "speedtester", "instance": "host"}
+	if err := sink.Publish(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	for name, want := range map[string]string{
+		"Content-Encoding":                  "snappy",
+		"Content-Type":                      "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+		"X-Scope-Orgid":                     "tenant",
+		"Authorization":                     "Basic dXNlcjpzZWNyZXQ=",
+	} {
+		if got := receiver.header.Get(name); got != want {
+			t.Errorf("got header %s: %q, want %q", name, got, want)
+		}
+	}
+	ts, runTs := now.UnixMilli(), run.UnixMilli()
+	common := `instance="host",job="speedtester"`
+	want := []string{
+		fmt.Sprintf(`test_gauge{%s} 95.5 @%d`, common, ts),
+		fmt.Sprintf(`test_result{%s} 1 @%d`, common, runTs),
+		fmt.Sprintf(`test_seconds_bucket{%s,le="+Inf"} 1 @%d`, common, ts),
+		fmt.Sprintf(`test_seconds_bucket{%s,le="0.5"} 0 @%d`, common, ts),
+		fmt.Sprintf(`test_seconds_bucket{%s,le="1"} 1 @%d`, common, ts),
+		fmt.Sprintf(`test_seconds_count{%s} 1 @%d`, common, ts),
+		fmt.Sprintf(`test_seconds_sum{%s} 0.7 @%d`, common, ts),
+		fmt.Sprintf(`test_total{%s,status="ok"} 3 @%d`, common, ts),
+	}
+	sort.Strings(want)
+	if !slices.Equal(receiver.series, want) {
+		t.Errorf("got series:\n%s\nwant:\n%s", strings.Join(receiver.series, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRemoteWriteSinkError(t *testing.T) {
+	receiver, url := newRemoteWriteReceiver(t)
+	receiver.status, receiver.message = http.StatusBadRequest, "out of order sample"
+	sink, err := NewRemoteWriteSink(url, prometheus.NewRegistry(), nil, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Publish(nil); err == nil || !strings.Contains(err.Error(), "400 Bad Request: out of order sample") {
+		t.Errorf("got error %v, want the status and the message", err)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0.5, "0.5"},
+		{10, "10"},
+		{0.99, "0.99"},
+		{1e-5, "1e-05"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.value); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+)
+
+// Time between attempts while waiting for the network.
+const networkRetryInterval = 5 * time.Second
+
+// RunWaitingForNetwork performs a run, retrying quickly on failures until one succeeds
+// or the wait is over, for one-shot runs on boot when the network may not be up yet.
+// Only the failures with the given reasons are retried; without reasons, all of them
+// but when the CLI is unavailable, as waiting cannot fix that.
+func (t *SpeedTester) RunWaitingForNetwork(wait time.Duration, reasons []string) (*Stats, error) {
+	clock := t.clock()
+	deadline := clock.Now().Add(wait)
+	for attempt := 1; ; attempt++ {
+		stats, err := t.TryRun(nil)
+		if err == nil || !retryable(err, reasons) {
+			return stats, err
+		}
+		if !clock.Now().Add(networkRetryInterval).Before(deadline) {
+			return nil, err
+		}
+		log.Printf("attempt %d failed (reason=%s), retrying in %s: %v", attempt, FailureReason(err), networkRetryInterval, err)
+		<-clock.After(networkRetryInterval)
+	}
+}
+
+// retryable returns whether a failed run is worth retrying given the retryable reasons.
+func retryable(err error, reasons []string) bool {
+	if len(reasons) == 0 {
+		return !errors.Is(err, ErrCLIUnavailable)
+	}
+	return slices.Contains(reasons, FailureReason(err))
+}
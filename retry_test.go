@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyCLI returns a fake CLI that fails the given number of times before printing
+// the fixture, and the file counting its executions.
+func flakyCLI(t *testing.T, failures int) (string, string) {
+	t.Helper()
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter := filepath.Join(t.TempDir(), "counter")
+	return fakeCLI(t, `
+n=$(($(cat '`+counter+`' 2>/dev/null || echo 0) + 1))
+echo $n > '`+counter+`'
+if [ $n -le `+strconv.Itoa(failures)+` ]; then
+  echo '[error] Configuration - Couldn'"'"'t resolve host name (HostNotFoundException)' >&2
+  exit 1
+fi
+cat '`+fixture+`'`), counter
+}
+
+func TestRunWaitingForNetwork(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wait         time.Duration
+		reasons      []string
+		missingCLI   bool
+		wantErr      error
+		wantAttempts int
+		wantElapsed  time.Duration
+	}{
+		{name: "first attempt", wait: time.Minute, wantAttempts: 1},
+		{name: "before the deadline", failures: 2, wait: time.Minute, wantAttempts: 3, wantElapsed: 2 * networkRetryInterval},
+		{name: "timeout", failures: 10, wait: 12 * time.Second, wantErr: ErrExec, wantAttempts: 3, wantElapsed: 2 * networkRetryInterval},
+		{name: "no wait", failures: 1, wantErr: ErrExec, wantAttempts: 1},
+		{name: "CLI unavailable", missingCLI: true, wait: time.Minute, wantErr: ErrCLIUnavailable},
+		{name: "retryable reason", failures: 2, wait: time.Minute, reasons: []string{"timeout", "exec"}, wantAttempts: 3, wantElapsed: 2 * networkRetryInterval},
+		{name: "non-retryable reason", failures: 2, wait: time.Minute, reasons: []string{"timeout"}, wantErr: ErrExec, wantAttempts: 1},
+		{name: "CLI unavailable, retryable", missingCLI: true, wait: 7 * time.Second, reasons: []string{"unavailable"}, wantErr: ErrCLIUnavailable, wantElapsed: networkRetryInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, counter := flakyCLI(t, tt.failures)
+			if tt.missingCLI {
+				cli = filepath.Join(t.TempDir(), "speedtest")
+			}
+			start := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+			clock := newFakeClock(start)
+			tester := &SpeedTester{Command: cli, Clock: clock}
+			tester.Metrics()
+
+			type result struct {
+				stats *Stats
+				err   error
+			}
+			done := make(chan result, 1)
+			go func() {
+				stats, err := tester.RunWaitingForNetwork(tt.wait, tt.reasons)
+				done <- result{stats, err}
+			}()
+			// Let the time pass whenever the retries wait.
+			var got result
+			for finished := false; !finished; {
+				select {
+				case got = <-done:
+					finished = true
+				case <-time.After(time.Millisecond):
+					if clock.Armed() > 0 {
+						clock.Advance(networkRetryInterval)
+					}
+				}
+			}
+
+			if tt.wantErr == nil && got.err != nil || !errors.Is(got.err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", got.err, tt.wantErr)
+			}
+			if tt.wantErr == nil && got.stats == nil {
+				t.Error("got no results")
+			}
+			attempts := 0
+			if data, err := os.ReadFile(counter); err == nil {
+				attempts, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if elapsed := clock.Now().Sub(start); elapsed != tt.wantElapsed {
+				t.Errorf("waited %s, want %s", elapsed, tt.wantElapsed)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err     error
+		reasons []string
+		want    bool
+	}{
+		{fmt.Errorf("%w: exit status 1", ErrExec), nil, true},
+		{fmt.Errorf("%w after 1m0s", ErrTimeout), nil, true},
+		{fmt.Errorf("%w: exec: not found", ErrCLIUnavailable), nil, false},
+		{fmt.Errorf("%w after 1m0s", ErrTimeout), []string{"timeout", "exec"}, true},
+		{fmt.Errorf("%w: exit status 1", ErrExec), []string{"timeout"}, false},
+		{fmt.Errorf("%w: exec: not found", ErrCLIUnavailable), []string{"unavailable"}, true},
+		{fmt.Errorf("%w: cannot open socket", fmt.Errorf("%w: %w", ErrUnreachable, ErrExec)), []string{"exec"}, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err, tt.reasons); got != tt.want {
+			t.Errorf("retryable(%v, %v) = %t, want %t", tt.err, tt.reasons, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Percentiles of the latency samples exposed via the latency label, when available.
+var latencyPercentiles = []struct {
+	label string
+	p     float64
+}{
+	{"p50", 50},
+	{"p90", 90},
+	{"p99", 99},
+}
+
+// LatencySamples are the individual latency measurements in milliseconds that newer
+// CLI versions can include in the results. Parsing is lenient: anything other than an
+// array of numbers is ignored, so an unexpected format doesn't break the runs.
+type LatencySamples []float64
+
+func (s *LatencySamples) UnmarshalJSON(data []byte) error {
+	var samples []float64
+	if err := json.Unmarshal(data, &samples); err != nil {
+		*s = nil
+		return nil
+	}
+	*s = samples
+	return nil
+}
+
+// Percentile returns the p-th percentile (0-100) of the samples, interpolating
+// linearly between the closest ranks. Returns NaN when there are no samples.
+func (s LatencySamples) Percentile(p float64) float64 {
+	if len(s) == 0 {
+		return math.NaN()
+	}
+	sorted := slices.Clone(s)
+	slices.Sort(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// setPercentiles publishes the percentiles of the samples, if any, on the given latency gauge.
+func setPercentiles(g *prometheus.GaugeVec, latency func(string) []string, samples LatencySamples) {
+	if len(samples) == 0 {
+		return
+	}
+	for _, p := range latencyPercentiles {
+		g.WithLabelValues(latency(p.label)...).Set(samples.Percentile(p.p))
+	}
+}
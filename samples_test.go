@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLatencySamplesPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples LatencySamples
+		p       float64
+		want    float64
+	}{
+		{"single", LatencySamples{42}, 99, 42},
+		{"median of odd", LatencySamples{30, 10, 20}, 50, 20},
+		{"median of even", LatencySamples{40, 10, 30, 20}, 50, 25},
+		{"interpolated", LatencySamples{12, 10, 11, 15, 30}, 90, 24},
+		{"near the top", LatencySamples{12, 10, 11, 15, 30}, 99, 29.4},
+		{"minimum", LatencySamples{12, 10, 11}, 0, 10},
+		{"maximum", LatencySamples{12, 10, 11}, 100, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.samples.Percentile(tt.p); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Percentile(%v) of %v = %v, want %v", tt.p, tt.samples, got, tt.want)
+			}
+		})
+	}
+	if got := LatencySamples(nil).Percentile(50); !math.IsNaN(got) {
+		t.Errorf("Percentile(50) without samples = %v, want NaN", got)
+	}
+	samples := LatencySamples{3, 1, 2}
+	samples.Percentile(50)
+	if samples[0] != 3 {
+		t.Error("Percentile sorted the samples in place")
+	}
+}
+
+func TestLatencySamplesUnmarshal(t *testing.T) {
+	tests := []struct {
+		data string
+		want LatencySamples
+	}{
+		{`[10.5, 11, 12]`, LatencySamples{10.5, 11, 12}},
+		{`[]`, LatencySamples{}},
+		{`null`, nil},
+		{`"n/a"`, nil},
+		{`[{"ms": 10}]`, nil},
+		{`{"p50": 10}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			var got LatencySamples
+			if err := json.Unmarshal([]byte(tt.data), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) || (got == nil) != (tt.want == nil) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSamplePercentileMetrics(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		ping     map[string]float64 // Expected percentiles, none when the samples are missing
+		download map[string]float64
+	}{
+		{fixture: "result.json"},
+		{fixture: "result_samples_invalid.json"},
+		{
+			fixture:  "result_samples.json",
+			ping:     map[string]float64{"p50": 12, "p90": 24, "p99": 29.4},
+			download: map[string]float64{"p50": 60, "p90": 100, "p99": 109},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			stats := loadResult(t, tt.fixture)
+			stats.Method = MethodOokla
+			s := &PrometheusStats{}
+			s.Init()
+			s.Update(stats)
+			// Without samples, only the idle, low, and high latencies are reported.
+			want := 3
+			if tt.ping != nil {
+				want += len(latencyPercentiles)
+			}
+			if got := testutil.CollectAndCount(s.PingLatency); got != want {
+				t.Errorf("got %d ping latency series, want %d", got, want)
+			}
+			labels := s.labelValues(stats)
+			for _, m := range []struct {
+				name  string
+				gauge *prometheus.GaugeVec
+				want  map[string]float64
+			}{
+				{"speedtest_ping_latency", s.PingLatency, tt.ping},
+				{"speedtest_download_latency", s.DownloadLatency, tt.download},
+			} {
+				for label, want := range m.want {
+					if got := testutil.ToFloat64(m.gauge.WithLabelValues(append(labels, label)...)); math.Abs(got-want) > 1e-9 {
+						t.Errorf("%s{latency=%q} = %v, want %v", m.name, label, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// runLoop performs a run right away and then on each scheduled time until the context is done.
+func (t *SpeedTester) runLoop(ctx context.Context, clock Clock) {
+	log.Printf("Statistics will be collected and processed every %s", t.Frequency.String())
+	t.runScheduled(clock.Now())
+	// A timer re-armed after each run, rather than a ticker, so the aligned
+	// boundaries are recomputed from the wall clock and cannot drift.
+	// Scheduled before arming the timer, so the next run is known once it is waiting.
+	next := t.NextRun(clock.Now(), clock.Now())
+	t.Schedule(next)
+	timer := clock.NewTimer(next.Sub(clock.Now()))
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+			t.runScheduled(clock.Now())
+			next = t.NextRun(next, clock.Now())
+			t.Schedule(next)
+			timer.Reset(next.Sub(clock.Now()))
+		}
+	}
+}
+
+// NextRun returns when the run following the one scheduled at prev should happen.
+// Without alignment that is one frequency later; otherwise it is the next wall-clock
+// boundary that is a multiple of the frequency since local midnight (e.g. :00, :15,
+// :30 and :45 every 15 minutes). Times already in the past are skipped, so a run
+// that takes longer than the frequency does not trigger back to back runs.
+func (t *SpeedTester) NextRun(prev, now time.Time) time.Time {
+	next := t.nextBoundary(prev)
+	if !next.After(now) {
+		next = t.nextBoundary(now)
+	}
+	return next
+}
+
+// nextBoundary returns the first scheduling boundary strictly after the given time.
+func (t *SpeedTester) nextBoundary(after time.Time) time.Time {
+	if !t.Align || t.Frequency <= 0 || t.Frequency > 24*time.Hour {
+		return after.Add(t.Frequency)
+	}
+	// Work on the wall clock so the boundaries stay put across DST changes; they
+	// restart at midnight when the frequency does not divide a day. The offset is
+	// passed as seconds and nanoseconds, as the nanoseconds of a whole day overflow
+	// an int on 32-bit platforms.
+	y, m, d := after.Date()
+	wall := time.Duration(after.Hour())*time.Hour +
+		time.Duration(after.Minute())*time.Minute +
+		time.Duration(after.Second())*time.Second +
+		time.Duration(after.Nanosecond())
+	offset := (wall/t.Frequency + 1) * t.Frequency
+	if offset >= 24*time.Hour {
+		return time.Date(y, m, d+1, 0, 0, 0, 0, after.Location())
+	}
+	next := time.Date(y, m, d, 0, 0, int(offset/time.Second), int(offset%time.Second), after.Location())
+	if !next.After(after) {
+		// A wall time repeated by a DST change is resolved to its first occurrence, while
+		// after is in the second one, where the boundary is the same distance ahead.
+		next = after.Add(offset - wall)
+	}
+	return next
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // The DST cases must not depend on the zoneinfo of the host
+)
+
+func TestNextBoundary(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(layout, value string) time.Time {
+		v, err := time.ParseInLocation(layout, value, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	const local = "2006-01-02 15:04:05.000 MST"
+	tests := []struct {
+		name      string
+		frequency time.Duration
+		align     bool
+		after     time.Time
+		want      time.Time
+	}{
+		{"unaligned", 15 * time.Minute, false, at(local, "2024-06-01 10:07:30.000 EDT"), at(local, "2024-06-01 10:22:30.000 EDT")},
+		{"aligned mid-slot", 15 * time.Minute, true, at(local, "2024-06-01 10:07:30.000 EDT"), at(local, "2024-06-01 10:15:00.000 EDT")},
+		{"aligned on a boundary", 15 * time.Minute, true, at(local, "2024-06-01 10:15:00.000 EDT"), at(local, "2024-06-01 10:30:00.000 EDT")},
+		{"aligned before midnight", 15 * time.Minute, true, at(local, "2024-06-01 23:50:00.000 EDT"), at(local, "2024-06-02 00:00:00.000 EDT")},
+		{"frequency not dividing a day", 7 * time.Hour, true, at(local, "2024-06-01 22:00:00.000 EDT"), at(local, "2024-06-02 00:00:00.000 EDT")},
+		{"sub-second", 250 * time.Millisecond, true, at(local, "2024-06-01 10:00:00.100 EDT"), at(local, "2024-06-01 10:00:00.250 EDT")},
+		{"sub-second late in the day", 250 * time.Millisecond, true, at(local, "2024-06-01 23:59:59.900 EDT"), at(local, "2024-06-02 00:00:00.000 EDT")},
+		{"sub-second unaligned", 300 * time.Millisecond, false, at(local, "2024-06-01 10:00:00.100 EDT"), at(local, "2024-06-01 10:00:00.400 EDT")},
+		// On 2024-03-10, 02:00 EST jumps to 03:00 EDT, so the boundaries in between don't exist.
+		{"spring forward, skipped boundary", 15 * time.Minute, true, at(local, "2024-03-10 01:50:00.000 EST"), at(local, "2024-03-10 03:00:00.000 EDT")},
+		{"spring forward, after the change", 15 * time.Minute, true, at(local, "2024-03-10 03:05:00.000 EDT"), at(local, "2024-03-10 03:15:00.000 EDT")},
+		// On 2024-11-03, 02:00 EDT goes back to 01:00 EST, so the boundaries in between happen twice.
+		{"fall back, first occurrence", 15 * time.Minute, true, at(local, "2024-11-03 01:20:00.000 EDT"), at(local, "2024-11-03 01:30:00.000 EDT")},
+		{"fall back, second occurrence", 15 * time.Minute, true, at(local, "2024-11-03 01:20:00.000 EST"), at(local, "2024-11-03 01:30:00.000 EST")},
+		{"fall back, sub-second in the repeated hour", 100 * time.Millisecond, true, at(local, "2024-11-03 01:30:00.050 EST"), at(local, "2024-11-03 01:30:00.100 EST")},
+		{"fall back, after the change", 15 * time.Minute, true, at(local, "2024-11-03 02:05:00.000 EST"), at(local, "2024-11-03 02:15:00.000 EST")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{Frequency: tt.frequency, Align: tt.align}
+			got := tester.nextBoundary(tt.after)
+			if !got.Equal(tt.want) {
+				t.Errorf("nextBoundary(%s) = %s, want %s", tt.after, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	base := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		align     bool
+		prev, now time.Time
+		want      time.Time
+	}{
+		{"one frequency later", false, base, base.Add(time.Minute), base.Add(15 * time.Minute)},
+		{"skips the missed runs", false, base, base.Add(40 * time.Minute), base.Add(55 * time.Minute)},
+		{"aligned, skips the missed boundaries", true, base, base.Add(40 * time.Minute), base.Add(45 * time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{Frequency: 15 * time.Minute, Align: tt.align}
+			if got := tester.NextRun(tt.prev, tt.now); !got.Equal(tt.want) {
+				t.Errorf("NextRun(%s, %s) = %s, want %s", tt.prev, tt.now, got, tt.want)
+			}
+		})
+	}
+}
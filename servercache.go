@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultServerCacheTTL = 24 * time.Hour
+
+// ServerListCache keeps the list of servers reported by the CLI on disk, as fetching
+// it takes a few seconds and requires the network. An expired list is fetched again,
+// but it is still used when that fails, e.g. while the network is briefly down.
+type ServerListCache struct {
+	Path    string // No caching when empty
+	TTL     time.Duration
+	Refresh bool // Whether to fetch the list even if the cached one is still valid
+}
+
+// cachedServerList is the content of the cache file.
+type cachedServerList struct {
+	FetchedAt time.Time    `json:"fetchedAt"`
+	Servers   []ServerInfo `json:"servers"`
+}
+
+// newServerListCache registers the flags to configure the cache of the server list.
+func newServerListCache(fs *flag.FlagSet) *ServerListCache {
+	c := new(ServerListCache)
+	fs.StringVar(&c.Path, "server-cache", "", "Path to a file where the list of servers is cached, to avoid fetching it from the CLI every time")
+	fs.DurationVar(&c.TTL, "server-cache-ttl", defaultServerCacheTTL, "Time after which the cached list of servers is fetched again")
+	fs.BoolVar(&c.Refresh, "refresh-servers", false, "Fetch the list of servers even if the cached one hasn't expired")
+	return c
+}
+
+// Servers returns the servers near the host from the cache, or from the CLI when the
+// cache is disabled, expired, or being refreshed.
+func (c *ServerListCache) Servers(command string) ([]ServerInfo, error) {
+	if c.Path == "" {
+		return ListServers(command)
+	}
+	cached, err := c.load()
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("ignoring the cached list of servers: %v", err)
+	}
+	if cached != nil && !c.Refresh && time.Since(cached.FetchedAt) < c.TTL {
+		return cached.Servers, nil
+	}
+	servers, err := ListServers(command)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		log.Printf("cannot fetch the list of servers, using the one cached at %s: %v", cached.FetchedAt.Format(time.RFC3339), err)
+		return cached.Servers, nil
+	}
+	data, err := json.Marshal(cachedServerList{FetchedAt: time.Now(), Servers: servers})
+	if err == nil {
+		err = writeFileAtomic(c.Path, data)
+	}
+	if err != nil {
+		log.Printf("cannot cache the list of servers: %v", err)
+	}
+	return servers, nil
+}
+
+func (c *ServerListCache) load() (*cachedServerList, error) {
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	cached := new(cachedServerList)
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeServerCache writes a cache file with a single server, fetched at the given time.
+func writeServerCache(t *testing.T, path string, fetchedAt time.Time) {
+	t.Helper()
+	data, err := json.Marshal(cachedServerList{FetchedAt: fetchedAt, Servers: []ServerInfo{{ID: 1, Name: "Cached"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerListCache(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "servers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		noCache   bool
+		cachedAgo time.Duration // Age of the cached list, if any
+		corrupt   bool
+		refresh   bool
+		failing   bool // Whether the CLI cannot fetch the list
+		want      []int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "disabled", noCache: true, want: []int{14774, 32940}, wantCalls: 1},
+		{name: "missing", want: []int{14774, 32940}, wantCalls: 1},
+		{name: "hit", cachedAgo: time.Hour, want: []int{1}},
+		{name: "expired", cachedAgo: 25 * time.Hour, want: []int{14774, 32940}, wantCalls: 1},
+		{name: "refresh", cachedAgo: time.Hour, refresh: true, want: []int{14774, 32940}, wantCalls: 1},
+		{name: "corrupt", corrupt: true, want: []int{14774, 32940}, wantCalls: 1},
+		{name: "expired, fetch failing", cachedAgo: 25 * time.Hour, failing: true, want: []int{1}, wantCalls: 1},
+		{name: "missing, fetch failing", failing: true, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			calls := filepath.Join(dir, "calls")
+			script := "echo call >> '" + calls + "'\ncat '" + fixture + "'"
+			if tt.failing {
+				script = "echo call >> '" + calls + "'\necho '[error] Cannot retrieve the server list' >&2; exit 1"
+			}
+			cache := &ServerListCache{Path: filepath.Join(dir, "servers.json"), TTL: defaultServerCacheTTL, Refresh: tt.refresh}
+			if tt.noCache {
+				cache.Path = ""
+			}
+			switch {
+			case tt.corrupt:
+				if err := os.WriteFile(cache.Path, []byte(`{"servers":`), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			case tt.cachedAgo > 0:
+				writeServerCache(t, cache.Path, time.Now().Add(-tt.cachedAgo))
+			}
+
+			servers, err := cache.Servers(fakeCLI(t, script))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if got := serverIDs(servers); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got the servers %v, want %v", got, tt.want)
+			}
+			data, _ := os.ReadFile(calls)
+			if got := strings.Count(string(data), "call"); got != tt.wantCalls {
+				t.Errorf("the CLI was called %d times, want %d", got, tt.wantCalls)
+			}
+
+			// A fetched list is cached, so the next lookup is a hit.
+			if tt.noCache || tt.failing {
+				return
+			}
+			cached, err := cache.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := serverIDs(cached.Servers); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("cached the servers %v, want %v", got, tt.want)
+			}
+			if tt.wantCalls > 0 && time.Since(cached.FetchedAt) > time.Minute {
+				t.Errorf("cached the list at %s, want now", cached.FetchedAt)
+			}
+		})
+	}
+}
+
+func serverIDs(servers []ServerInfo) []int {
+	var ids []int
+	for _, s := range servers {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
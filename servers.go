@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ParseServers parses the IDs of the servers to rotate through.
+func ParseServers(values []string) ([]int, error) {
+	var servers []int
+	for _, v := range values {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid server ID %q", v)
+		}
+		servers = append(servers, id)
+	}
+	return servers, nil
+}
+
+// formatServers returns the IDs of the servers as a comma-separated list.
+func formatServers(servers []int) string {
+	ids := make([]string, len(servers))
+	for i, id := range servers {
+		ids[i] = strconv.Itoa(id)
+	}
+	return strings.Join(ids, ",")
+}
+
+// unreachable returns whether the error shows that the server, rather than the CLI or
+// the link, is the problem, so another server can be tried instead. Other failures, like
+// a crash of the CLI or a timeout, fail the run right away.
+func unreachable(err error) bool {
+	return errors.Is(err, ErrServerNotFound) || errors.Is(err, ErrUnreachable)
+}
+
+// executeServers runs the test against the configured servers in rotation, starting
+// with the next one in the cycle. Unreachable servers are skipped in favor of the
+// following ones, up to a full cycle. Without servers, it uses the pinned one, if any.
+func (t *SpeedTester) executeServers(logger *log.Logger, iface string) (*Stats, error) {
+	if len(t.Servers) == 0 {
+		t.lastAttempt = t.ServerID
+		return t.execute(logger, t.ServerID, iface)
+	}
+	start := t.nextServer
+	t.nextServer = (t.nextServer + 1) % len(t.Servers)
+	var err error
+	for i := range t.Servers {
+		id := t.Servers[(start+i)%len(t.Servers)]
+		t.lastAttempt = id
+		var stats *Stats
+		if stats, err = t.execute(logger, id, iface); err == nil || !unreachable(err) {
+			return stats, err
+		}
+		logger.Printf("Server ID %d failed (reason=%s), skipping it: %v", id, FailureReason(err), err)
+		t.promStats.ServersSkipped.WithLabelValues(strconv.Itoa(id)).Inc()
+		if i < len(t.Servers)-1 {
+			t.retries++
+		}
+	}
+	return nil, err
+}
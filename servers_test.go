@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    []int
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", values: []string{"14774", "32940"}, want: []int{14774, 32940}},
+		{name: "not a number", values: []string{"14774", "unc"}, wantErr: true},
+		{name: "zero", values: []string{"0"}, wantErr: true},
+		{name: "negative", values: []string{"-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseServers(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("%w: exit status 1", ErrServerNotFound), true},
+		{fmt.Errorf("%w: exit status 1", ErrUnreachable), true},
+		{fmt.Errorf("%w: signal: segmentation fault", ErrExec), false},
+		{fmt.Errorf("%w after 1m0s", ErrTimeout), false},
+		{fmt.Errorf("%w: unexpected end of JSON input", ErrParse), false},
+		{fmt.Errorf("%w: exec: not found", ErrCLIUnavailable), false},
+	}
+	for _, tt := range tests {
+		if got := unreachable(tt.err); got != tt.want {
+			t.Errorf("unreachable(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+// serversCLI returns a fake CLI that behaves as given for each server ID, printing the
+// fixture for the rest, and the file where it logs the server ID of each execution.
+func serversCLI(t *testing.T, behaviors map[int]string) (string, string) {
+	t.Helper()
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := filepath.Join(t.TempDir(), "calls")
+	var cases strings.Builder
+	for id, script := range behaviors {
+		fmt.Fprintf(&cases, "%d) %s ;;\n", id, script)
+	}
+	// The server ID follows --server-id, after the default arguments.
+	return fakeCLI(t, `
+echo "$5" >> '`+calls+`'
+case "$5" in
+`+cases.String()+`esac
+cat '`+fixture+`'`), calls
+}
+
+func TestExecuteServers(t *testing.T) {
+	const (
+		notFound  = `echo '[error] No servers defined (NoServersException)' >&2; exit 1`
+		refused   = `echo '[error] Error: [111] Cannot open socket: Connection refused' >&2; exit 1`
+		crash     = `echo 'Segmentation fault' >&2; exit 139`
+		hang      = `sleep 5`
+		noConnect = `echo '[error] Error: [0] Cannot open socket: Timeout occurred in connect.' >&2; exit 1`
+	)
+	tests := []struct {
+		name      string
+		behaviors map[int]string
+		want      error // Expected error, if any
+		wantCalls []string
+		skipped   []string // Servers counted as skipped
+	}{
+		{name: "first server works", wantCalls: []string{"1"}},
+		{name: "skips a missing server", behaviors: map[int]string{1: notFound}, wantCalls: []string{"1", "2"}, skipped: []string{"1"}},
+		{name: "skips a refused connection", behaviors: map[int]string{1: refused}, wantCalls: []string{"1", "2"}, skipped: []string{"1"}},
+		{name: "skips a connection timeout", behaviors: map[int]string{1: noConnect, 2: refused}, wantCalls: []string{"1", "2", "3"}, skipped: []string{"1", "2"}},
+		{name: "fails fast when the CLI crashes", behaviors: map[int]string{1: crash}, want: ErrExec, wantCalls: []string{"1"}},
+		{name: "fails fast on a timeout", behaviors: map[int]string{1: hang}, want: ErrTimeout, wantCalls: []string{"1"}},
+		{name: "none reachable", behaviors: map[int]string{1: refused, 2: notFound, 3: refused}, want: ErrUnreachable, wantCalls: []string{"1", "2", "3"}, skipped: []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, calls := serversCLI(t, tt.behaviors)
+			tester := &SpeedTester{Command: cli, Servers: []int{1, 2, 3}, Timeout: 500 * time.Millisecond}
+			tester.Metrics()
+			_, err := tester.executeServers(testLogger(t), "")
+			if tt.want == nil && err != nil || !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			data, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Fields(string(data)); strings.Join(got, ",") != strings.Join(tt.wantCalls, ",") {
+				t.Errorf("tried the servers %v, want %v", got, tt.wantCalls)
+			}
+			for _, id := range []string{"1", "2", "3"} {
+				want := 0.0
+				if slices.Contains(tt.skipped, id) {
+					want = 1
+				}
+				if got := testutil.ToFloat64(tester.Metrics().ServersSkipped.WithLabelValues(id)); got != want {
+					t.Errorf("speedtest_servers_skipped_total{server_id=%q} = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteServersRotation(t *testing.T) {
+	cli, calls := serversCLI(t, nil)
+	tester := &SpeedTester{Command: cli, Servers: []int{1, 2, 3}}
+	tester.Metrics()
+	for range 4 {
+		if _, err := tester.executeServers(testLogger(t), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(string(data)), ","); got != "1,2,3,1" {
+		t.Errorf("tried the servers %s, want 1,2,3,1", got)
+	}
+}
+
+func TestRunRetries(t *testing.T) {
+	const refused = `echo '[error] Error: [111] Cannot open socket: Connection refused' >&2; exit 1`
+	tests := []struct {
+		name      string
+		behaviors map[int]string
+		want      float64
+	}{
+		{name: "first server works", want: 0},
+		{name: "one server refused", behaviors: map[int]string{1: refused}, want: 1},
+		{name: "two servers refused", behaviors: map[int]string{1: refused, 2: refused}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, _ := serversCLI(t, tt.behaviors)
+			tester := &SpeedTester{Command: cli, Servers: []int{1, 2, 3}}
+			metrics := tester.Metrics()
+			// A previous run with retries must not leak into the next one.
+			metrics.Retries.Set(5)
+			if err := tester.Run(); err != nil {
+				t.Fatal(err)
+			}
+			if got := testutil.ToFloat64(metrics.Retries); got != tt.want {
+				t.Errorf("speedtest_run_retries = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearFailedServer(t *testing.T) {
+	const crash = `echo 'Segmentation fault' >&2; exit 139`
+	cli, _ := serversCLI(t, map[int]string{32940: crash})
+	tester := &SpeedTester{Command: cli, Servers: []int{14774, 32940}, OnFailure: OnFailureClear}
+	metrics := tester.Metrics()
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tester.Run(); err == nil {
+		t.Fatal("the run succeeded, want a failure")
+	}
+	// The second run failed on 32940, so the results of 14774 are still valid.
+	if got, want := labelValues(t, metrics, "speedtest_download_speed", "server_id"), []string{"14774"}; !slices.Equal(got, want) {
+		t.Errorf("speedtest_download_speed has series for servers %q, want %q", got, want)
+	}
+}
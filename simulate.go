@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Range is an interval of values, accepted as a flag with the format min:max.
+type Range struct {
+	Min float64
+	Max float64
+}
+
+func (r *Range) String() string {
+	return fmt.Sprintf("%g:%g", r.Min, r.Max)
+}
+
+func (r *Range) Set(value string) error {
+	lo, hi, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("expected min:max")
+	}
+	var err error
+	if r.Min, err = strconv.ParseFloat(lo, 64); err != nil {
+		return err
+	}
+	if r.Max, err = strconv.ParseFloat(hi, 64); err != nil {
+		return err
+	}
+	if r.Min < 0 || r.Max < r.Min {
+		return fmt.Errorf("expected 0 <= min <= max")
+	}
+	return nil
+}
+
+// Random returns a uniformly distributed value within the range.
+func (r Range) Random() float64 {
+	return r.Min + rand.Float64()*(r.Max-r.Min)
+}
+
+// SyntheticRunner generates plausible results instead of running the CLI, with
+// occasional failures and packet loss, to develop dashboards and alerts end to end
+// without a real link.
+type SyntheticRunner struct {
+	DownloadMbps Range
+	UploadMbps   Range
+	PingMs       Range
+	LossPercent  Range
+	LossRate     float64 // Probability of a run with packet loss
+	FailureRate  float64 // Probability of a failed run
+}
+
+// NewSyntheticRunner creates a runner with the ranges of a typical residential link.
+func NewSyntheticRunner() *SyntheticRunner {
+	return &SyntheticRunner{
+		DownloadMbps: Range{Min: 80, Max: 120},
+		UploadMbps:   Range{Min: 10, Max: 20},
+		PingMs:       Range{Min: 8, Max: 25},
+		LossPercent:  Range{Min: 0.5, Max: 5},
+		LossRate:     0.1,
+		FailureRate:  0.05,
+	}
+}
+
+// Run returns synthetic results, or one of the errors the CLI can produce.
+func (s *SyntheticRunner) Run(logger *log.Logger) (*Stats, error) {
+	logger.Println("Generating synthetic results")
+	if rand.Float64() < s.FailureRate {
+		failures := []error{ErrTimeout, ErrExec, ErrParse}
+		return nil, fmt.Errorf("%w: simulated failure", failures[rand.IntN(len(failures))])
+	}
+	ping := s.PingMs.Random()
+	stats := &Stats{
+		Ping: &PingStats{
+			Latency: ping,
+			Jitter:  ping * (0.05 + rand.Float64()*0.2),
+			Low:     ping * (0.8 + rand.Float64()*0.2),
+			High:    ping * (1 + rand.Float64()*0.5),
+		},
+		Download: s.bandwidth(s.DownloadMbps.Random(), ping),
+		Upload:   s.bandwidth(s.UploadMbps.Random(), ping),
+		ISP:      "Simulated",
+		Server: &ServerInfo{
+			ID:       1,
+			Host:     "localhost",
+			Name:     "Simulated",
+			Location: "Local",
+			Country:  "Local",
+		},
+		Method: MethodSimulated,
+	}
+	if rand.Float64() < s.LossRate {
+		stats.PacketLoss = s.LossPercent.Random()
+	}
+	return stats, nil
+}
+
+// bandwidth returns a 10 seconds test at the given rate, with the latency under load
+// a few times higher than the idle one.
+func (s *SyntheticRunner) bandwidth(mbps, ping float64) *BandwidthStats {
+	const elapsed = 10000
+	rate := int64(mbps * 1e6 / 8)
+	loaded := ping * (1.5 + rand.Float64()*2)
+	return &BandwidthStats{
+		Bandwidth: rate,
+		Bytes:     rate * elapsed / 1000,
+		Elapsed:   elapsed,
+		Latency: &LatencyStats{
+			IQM:    loaded,
+			Low:    ping,
+			High:   loaded * (1.2 + rand.Float64()),
+			Jitter: loaded * (0.1 + rand.Float64()*0.2),
+		},
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+func TestRangeSet(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Range
+		wantErr bool
+	}{
+		{value: "80:120", want: Range{Min: 80, Max: 120}},
+		{value: "0.5:0.5", want: Range{Min: 0.5, Max: 0.5}},
+		{value: "80", wantErr: true},
+		{value: "a:120", wantErr: true},
+		{value: "80:b", wantErr: true},
+		{value: "120:80", wantErr: true},
+		{value: "-1:5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			var got Range
+			err := got.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %s, want %s", &got, &tt.want)
+			}
+		})
+	}
+}
+
+func TestSyntheticRunner(t *testing.T) {
+	const runs = 1000
+	logger := log.New(io.Discard, "", 0)
+	tests := []struct {
+		name        string
+		failureRate float64
+		lossRate    float64
+	}{
+		{name: "never failing", lossRate: 0.5},
+		{name: "without loss", failureRate: 0.2},
+		{name: "always with loss", lossRate: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSyntheticRunner()
+			s.FailureRate, s.LossRate = tt.failureRate, tt.lossRate
+			var failures, losses int
+			for range runs {
+				stats, err := s.Run(logger)
+				if err != nil {
+					if !errors.Is(err, ErrTimeout) && !errors.Is(err, ErrExec) && !errors.Is(err, ErrParse) {
+						t.Fatalf("got unexpected error %v", err)
+					}
+					failures++
+					continue
+				}
+				if err := stats.HasError(); err != nil {
+					t.Fatalf("got incomplete results: %v", err)
+				}
+				if stats.Method != MethodSimulated {
+					t.Errorf("got method %q, want %q", stats.Method, MethodSimulated)
+				}
+				inRange(t, "download", stats.Download.GetBandWithInMbps(), s.DownloadMbps, 1e-4)
+				inRange(t, "upload", stats.Upload.GetBandWithInMbps(), s.UploadMbps, 1e-4)
+				inRange(t, "ping", stats.Ping.Latency, s.PingMs, 0)
+				if p := stats.Ping; p.Low > p.Latency || p.High < p.Latency || p.Jitter <= 0 {
+					t.Errorf("got inconsistent ping %+v", p)
+				}
+				for _, b := range []*BandwidthStats{stats.Download, stats.Upload} {
+					if l := b.Latency; l.IQM < stats.Ping.Latency || l.High < l.IQM || b.Bytes != b.Bandwidth*10 {
+						t.Errorf("got inconsistent bandwidth %+v with latency %+v", b, l)
+					}
+				}
+				if stats.PacketLoss > 0 {
+					losses++
+					inRange(t, "packet loss", stats.PacketLoss, s.LossPercent, 0)
+				}
+			}
+			// The rates are probabilities, so only check them coarsely.
+			checkRate(t, "failure", failures, runs, tt.failureRate)
+			checkRate(t, "loss", losses, runs-failures, tt.lossRate)
+		})
+	}
+}
+
+// inRange checks that the value is within the range, with the given tolerance for rounding.
+func inRange(t *testing.T, name string, value float64, r Range, tolerance float64) {
+	t.Helper()
+	if value < r.Min-tolerance || value > r.Max+tolerance {
+		t.Errorf("got %s %v, want it within %s", name, value, &r)
+	}
+}
+
+func checkRate(t *testing.T, name string, count, total int, rate float64) {
+	t.Helper()
+	got := float64(count) / float64(total)
+	if (rate == 0 || rate == 1) && got != rate || got < rate-0.1 || got > rate+0.1 {
+		t.Errorf("got a %s rate of %v, want about %v", name, got, rate)
+	}
+}
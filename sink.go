@@ -0,0 +1,13 @@
+package main
+
+import "errors"
+
+// Sink receives the results of every successful speed test, in addition to Prometheus.
+type Sink interface {
+	Name() string
+	Publish(stats *Stats) error
+}
+
+// errSyslogUnavailable is returned when syslog cannot be reached or is not supported on
+// the platform, which only disables the sink, unlike an invalid configuration.
+var errSyslogUnavailable = errors.New("syslog unavailable")
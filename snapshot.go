@@ -0,0 +1,109 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// Gather implements prometheus.Gatherer, waiting for the results of a run being
+// published, so a scrape sees either the previous or the new results, never a mix.
+func (s *PrometheusStats) Gather() ([]*dto.MetricFamily, error) {
+	s.snapshotMu.RLock()
+	defer s.snapshotMu.RUnlock()
+	families, err := s.Registry.Gather()
+	if s.RunTimestamps && len(s.resultTimes) > 0 {
+		s.stampResults(families)
+	}
+	return families, err
+}
+
+// Families aggregating the results of several runs, which keep the time of the scrape.
+var aggregateFamilies = []string{
+	"speedtest_download_mbps_min",
+	"speedtest_download_mbps_max",
+	"speedtest_download_mbps_ewma",
+	"speedtest_upload_mbps_ewma",
+	"speedtest_ping_latency_ms_ewma",
+	"speedtest_packet_loss_percent",
+}
+
+// Separates the label values in the keys of resultTimes.
+const seriesKeySep = "\xff"
+
+// stampResults sets the timestamp of each result series to the time of the run that
+// published it, leaving the aggregates and the rest of the metrics with the time of the scrape.
+func (s *PrometheusStats) stampResults(families []*dto.MetricFamily) {
+	for _, f := range families {
+		if slices.Contains(aggregateFamilies, f.GetName()) {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			values := make([]string, len(s.labelNames))
+			for _, l := range m.GetLabel() {
+				if i := slices.Index(s.labelNames, l.GetName()); i >= 0 {
+					values[i] = l.GetValue()
+				}
+			}
+			if ts, ok := s.resultTimes[strings.Join(values, seriesKeySep)]; ok {
+				m.TimestampMs = proto.Int64(ts.UnixMilli())
+			}
+		}
+	}
+}
+
+// setResultTime records the time of the run that published the series with the given label values.
+func (s *PrometheusStats) setResultTime(values []string, ts time.Time) {
+	if ts.IsZero() {
+		return
+	}
+	if s.resultTimes == nil {
+		s.resultTimes = make(map[string]time.Time)
+	}
+	s.resultTimes[strings.Join(values, seriesKeySep)] = ts
+}
+
+// deleteResultTimes forgets the time of the runs that published the series matching the given labels.
+func (s *PrometheusStats) deleteResultTimes(labels prometheus.Labels) {
+	maps.DeleteFunc(s.resultTimes, func(key string, _ time.Time) bool {
+		values := strings.Split(key, seriesKeySep)
+		for name, value := range labels {
+			if values[slices.Index(s.labelNames, name)] != value {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Atomically applies the given updates to the metrics while no scrape is in progress.
+func (s *PrometheusStats) Atomically(update func()) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	update()
+}
+
+// publishResults swaps the latest results and their metrics in a single step. The
+// results must be complete, as they are shared with the readers of Latest().
+func (t *SpeedTester) publishResults(stats *Stats) {
+	if stats.Timestamp.IsZero() {
+		stats.Timestamp = t.clock().Now()
+	}
+	t.promStats.Atomically(func() {
+		t.promStats.Update(stats)
+		t.updateAverages(stats)
+		t.promStats.Retries.Set(float64(t.retries))
+	})
+	t.latest.Store(stats)
+	t.publishedAt = t.clock().Now()
+}
+
+// Latest returns the last published results, nil when there are none. They must not be modified.
+func (t *SpeedTester) Latest() *Stats {
+	return t.latest.Load()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunTimestamps(t *testing.T) {
+	// Each interface measures at a different time, one hour after the other.
+	measured := map[string]time.Time{
+		"eth0": time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC),
+		"eth1": time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "scrape time", enabled: false},
+		{name: "run time", enabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The interface follows --interface, after the default arguments.
+			cli := fakeCLI(t, `
+case "$5" in
+eth0) ts=2024-01-01T01:00:00Z ;;
+eth1) ts=2024-01-01T02:00:00Z ;;
+esac
+sed "s/2024-01-01T00:00:00Z/$ts/" '`+fixture+`'`)
+			tester := &SpeedTester{Command: cli, Interfaces: []string{"eth0", "eth1"}, RunTimestamps: tt.enabled, Clock: newFakeClock(measured["eth1"].Add(time.Hour))}
+			metrics := tester.Metrics()
+			// Before any results, nothing is stamped.
+			for name, ms := range timestamps(t, metrics) {
+				if ms != 0 {
+					t.Errorf("%s has the timestamp %d before any run", name, ms)
+				}
+			}
+			for range measured {
+				if err := tester.Run(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := timestamps(t, metrics)
+			for iface, ts := range measured {
+				var want int64
+				if tt.enabled {
+					want = ts.UnixMilli()
+				}
+				for _, name := range []string{"speedtest_download_speed", "speedtest_upload_speed", "speedtest_ping_latency", "speedtest_packet_loss"} {
+					if stamp := got[name+"/"+iface]; stamp != want {
+						t.Errorf("%s of %s has the timestamp %d, want %d", name, iface, stamp, want)
+					}
+				}
+				// The aggregates of several runs keep the time of the scrape.
+				for _, name := range []string{"speedtest_download_mbps_min", "speedtest_download_mbps_max", "speedtest_packet_loss_percent"} {
+					if stamp, ok := got[name+"/"+iface]; !ok || stamp != 0 {
+						t.Errorf("%s of %s has the timestamp %d (exposed: %t), want none", name, iface, stamp, ok)
+					}
+				}
+			}
+			// The metrics of the exporter keep the time of the scrape.
+			for _, name := range []string{"speedtest_total_requests", "speedtest_consecutive_successes", "speedtest_cli_output_bytes"} {
+				if stamp, ok := got[name+"/"]; !ok || stamp != 0 {
+					t.Errorf("%s has the timestamp %d (exposed: %t), want none", name, stamp, ok)
+				}
+			}
+		})
+	}
+}
+
+// timestamps returns the timestamp in ms of each gathered metric by name and
+// interface, separated by a slash, from its last series.
+func timestamps(t *testing.T, metrics *PrometheusStats) map[string]int64 {
+	t.Helper()
+	families, err := metrics.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamps := make(map[string]int64)
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var iface string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "interface" {
+					iface = l.GetValue()
+				}
+			}
+			stamps[f.GetName()+"/"+iface] = m.GetTimestampMs()
+		}
+	}
+	return stamps
+}
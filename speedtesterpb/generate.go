@@ -0,0 +1,5 @@
+// Package speedtesterpb contains the protobuf messages and the gRPC client and server
+// of the Collector service, which receives the results of the speed tests.
+package speedtesterpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative speedtester.proto
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// persistedState is the state restored across restarts with --state-file, so the
+// alerts based on the streaks survive a redeploy.
+type persistedState struct {
+	ConsecutiveFailures  int `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int `json:"consecutiveSuccesses"`
+}
+
+// restoreState loads the streaks from the state file. A missing or corrupt file is
+// logged and ignored, starting from scratch, as it will be rewritten after the next run.
+func (t *SpeedTester) restoreState() {
+	data, err := os.ReadFile(t.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	var state persistedState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err == nil && (state.ConsecutiveFailures < 0 || state.ConsecutiveSuccesses < 0) {
+		err = errors.New("negative streaks")
+	}
+	if err != nil {
+		log.Printf("Ignoring the state file %s: %v", t.StateFile, err)
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failures, t.successes = state.ConsecutiveFailures, state.ConsecutiveSuccesses
+	log.Printf("Restored %d consecutive failures and %d consecutive successes from %s", t.failures, t.successes, t.StateFile)
+}
+
+// saveState persists the streaks after a run.
+func (t *SpeedTester) saveState(failures, successes int) error {
+	data, err := json.Marshal(persistedState{ConsecutiveFailures: failures, ConsecutiveSuccesses: successes})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.StateFile, data)
+}
+
+// writeFileAtomic writes the file via a temporary one in the same directory, renamed
+// into place, so the readers (or a restart after a crash) never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	tests := []struct {
+		name                string
+		failures, successes int
+	}{
+		{"none", 0, 0},
+		{"failures", 3, 0},
+		{"successes", 0, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := (&SpeedTester{StateFile: path}).saveState(tt.failures, tt.successes); err != nil {
+				t.Fatal(err)
+			}
+			// After a restart, the streaks are exposed before the first run.
+			tester := &SpeedTester{StateFile: path}
+			tester.restoreState()
+			metrics := tester.Metrics()
+			if got := testutil.ToFloat64(metrics.Failures); got != float64(tt.failures) {
+				t.Errorf("speedtest_consecutive_failures = %v, want %d", got, tt.failures)
+			}
+			if got := testutil.ToFloat64(metrics.Successes); got != float64(tt.successes) {
+				t.Errorf("speedtest_consecutive_successes = %v, want %d", got, tt.successes)
+			}
+		})
+	}
+}
+
+func TestRestoreStateInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // Content of the state file, if any
+	}{
+		{name: "missing"},
+		{name: "empty", content: " "},
+		{name: "corrupt", content: `{"consecutiveFailures":`},
+		{name: "wrong type", content: `{"consecutiveFailures":"3"}`},
+		{name: "negative", content: `{"consecutiveFailures":-1,"consecutiveSuccesses":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tester := &SpeedTester{StateFile: path}
+			tester.restoreState()
+			if tester.failures != 0 || tester.successes != 0 {
+				t.Errorf("restored %d failures and %d successes, want none", tester.failures, tester.successes)
+			}
+		})
+	}
+}
+
+func TestRunSavesState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	tester := newLoopTester(newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+	tester.StateFile = path
+	tester.Simulator.FailureRate = 1
+	for range 2 {
+		tester.Run()
+	}
+	restored := &SpeedTester{StateFile: path}
+	restored.restoreState()
+	if restored.failures != 2 || restored.successes != 0 {
+		t.Errorf("restored %d failures and %d successes, want 2 and 0", restored.failures, restored.successes)
+	}
+
+	// A success after the restart resets the restored failures.
+	tester = newLoopTester(newFakeClock(time.Date(2024, 6, 1, 11, 0, 0, 0, time.UTC)))
+	tester.StateFile = path
+	tester.failures = 2
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), `{"consecutiveFailures":0,"consecutiveSuccesses":1}`; got != want {
+		t.Errorf("got the state %s, want %s", got, want)
+	}
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) != 0 {
+		t.Errorf("left the temporary files %v", matches)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusConfig describes the resolved configuration of the runner.
+type StatusConfig struct {
+	Frequency  string   `json:"frequency"`
+	Server     string   `json:"server"` // "auto" when the CLI selects it, or the pinned ID
+	Timeout    string   `json:"timeout"`
+	Interfaces []string `json:"interfaces,omitempty"`
+	BestOf     int      `json:"bestOf"`
+	OnFailure  string   `json:"onFailure"`
+	OnMissing  string   `json:"onMissingServer"`
+}
+
+// Status describes the current state of the runner.
+type Status struct {
+	Config               StatusConfig `json:"config"`
+	LastRunID            string       `json:"lastRunId,omitempty"`
+	LastRun              *time.Time   `json:"lastRun"`
+	LastError            string       `json:"lastError,omitempty"`
+	LastErrorTime        *time.Time   `json:"lastErrorTime,omitempty"`
+	ConsecutiveSuccesses int          `json:"consecutiveSuccesses"`
+	ConsecutiveFailures  int          `json:"consecutiveFailures"`
+	NextRun              *time.Time   `json:"nextRun"`
+	LastResults          *Stats       `json:"lastResults,omitempty"`
+}
+
+// Status returns a snapshot of the configuration and the state of the runner.
+func (t *SpeedTester) Status() *Status {
+	server := "auto"
+	if t.ServerID > 0 {
+		server = strconv.Itoa(t.ServerID)
+	}
+	if len(t.Servers) > 0 {
+		server = formatServers(t.Servers)
+	}
+	status := &Status{
+		Config: StatusConfig{
+			Frequency:  t.Frequency.String(),
+			Server:     server,
+			Timeout:    t.Timeout.String(),
+			Interfaces: t.Interfaces,
+			BestOf:     max(t.BestOf, 1),
+			OnFailure:  t.OnFailure,
+			OnMissing:  t.OnMissingServer,
+		},
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status.LastRunID = t.lastRunID
+	status.LastRun = timeOrNil(t.lastRun)
+	status.LastError = t.lastError
+	status.LastErrorTime = timeOrNil(t.errorTime)
+	status.ConsecutiveSuccesses = t.successes
+	status.ConsecutiveFailures = t.failures
+	status.NextRun = timeOrNil(t.nextRun)
+	status.LastResults = t.Latest()
+	return status
+}
+
+// StatusHandler returns an HTTP handler that exposes Status() as JSON.
+func (t *SpeedTester) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t.Status())
+	})
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
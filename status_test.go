@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// getStatus returns the response of the status handler decoded as generic JSON.
+func getStatus(t *testing.T, tester *SpeedTester) map[string]any {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	tester.StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got content type %q", got)
+	}
+	var status map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestStatusHandler(t *testing.T) {
+	tester := newLoopTester(realClock{})
+	tester.ServerID = 14774
+	tester.Timeout = time.Minute
+	tester.Interfaces = []string{"eth0"}
+	tester.OnMissingServer = OnMissingServerFail
+
+	status := getStatus(t, tester)
+	if got, want := slices.Sorted(maps.Keys(status)), []string{"config", "consecutiveFailures", "consecutiveSuccesses", "lastRun", "nextRun"}; !slices.Equal(got, want) {
+		t.Errorf("before any run, got the fields %q, want %q", got, want)
+	}
+	wantConfig := map[string]any{
+		"frequency":       "15m0s",
+		"server":          "14774",
+		"timeout":         "1m0s",
+		"interfaces":      []any{"eth0"},
+		"bestOf":          1.0,
+		"onFailure":       OnFailureRetain,
+		"onMissingServer": OnMissingServerFail,
+	}
+	if got := status["config"]; !jsonEqual(got, wantConfig) {
+		t.Errorf("got the config %v, want %v", got, wantConfig)
+	}
+	if status["lastRun"] != nil || status["nextRun"] != nil {
+		t.Errorf("got lastRun %v and nextRun %v before any run, want null", status["lastRun"], status["nextRun"])
+	}
+
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	tester.Simulator.FailureRate = 1
+	if err := tester.Run(); err == nil {
+		t.Fatal("the run succeeded, want a failure")
+	}
+	status = getStatus(t, tester)
+	for _, field := range []string{"lastRunId", "lastRun", "lastError", "lastErrorTime", "lastResults"} {
+		if status[field] == nil {
+			t.Errorf("the field %s is missing after the runs", field)
+		}
+	}
+	if status["consecutiveFailures"] != 1.0 || status["consecutiveSuccesses"] != 0.0 {
+		t.Errorf("got %v failures and %v successes, want 1 and 0", status["consecutiveFailures"], status["consecutiveSuccesses"])
+	}
+	if _, err := time.Parse(time.RFC3339, status["lastRun"].(string)); err != nil {
+		t.Errorf("lastRun is not an RFC 3339 time: %v", err)
+	}
+}
+
+func TestStatusHandlerMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newLoopTester(realClock{}).StatusHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// jsonEqual returns whether two values decoded from JSON are equal.
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Default template of the status line, e.g. ↓95.3 ↑9.8 ping 12ms loss 0% @UNC Chapel Hill
+const defaultStatusLineFormat = `↓{{printf "%.1f" .Download}} ↑{{printf "%.1f" .Upload}} ping {{printf "%.0f" .Ping}}ms loss {{printf "%.0f" .Loss}}% @{{.Server}}`
+
+// StatusLine holds the values available to the template of the status line.
+type StatusLine struct {
+	Download float64 // Mbps
+	Upload   float64 // Mbps
+	Ping     float64 // Idle latency in ms
+	Jitter   float64 // Idle jitter in ms
+	Loss     float64 // Packet loss in percentage
+	Server   string  // Name of the server
+	Location string  // Location of the server
+	ISP      string
+	Method   string
+	RunID    string
+}
+
+// StatusLineSink writes a compact line with the results of each run for a router or
+// terminal status display, replacing the previous one. It is written to a file, or
+// to stdout when the path is "-", where the line is rewritten in place.
+type StatusLineSink struct {
+	Path     string
+	Template *template.Template
+	stdout   io.Writer
+}
+
+// NewStatusLineSink creates a sink writing to the given path with the given template.
+func NewStatusLineSink(path, format string) (Sink, error) {
+	tmpl, err := template.New("status-line").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	return &StatusLineSink{Path: path, Template: tmpl, stdout: os.Stdout}, nil
+}
+
+func (s *StatusLineSink) Name() string {
+	return "status-line"
+}
+
+func (s *StatusLineSink) Publish(stats *Stats) error {
+	line, err := s.Render(stats)
+	if err != nil {
+		return err
+	}
+	if s.Path == "-" {
+		// Return to the start of the line and clear it, so the previous line is overwritten.
+		_, err := fmt.Fprint(s.stdout, "\r\033[K"+line)
+		return err
+	}
+	return writeFileAtomic(s.Path, []byte(line+"\n"))
+}
+
+// Render returns the status line for the given results, on a single line.
+func (s *StatusLineSink) Render(stats *Stats) (string, error) {
+	line := StatusLine{
+		Loss:   stats.PacketLoss,
+		ISP:    stats.ISP,
+		Method: stats.Method,
+		RunID:  stats.RunID,
+	}
+	if stats.Download != nil {
+		line.Download = stats.Download.GetBandWithInMbps()
+	}
+	if stats.Upload != nil {
+		line.Upload = stats.Upload.GetBandWithInMbps()
+	}
+	if stats.Ping != nil {
+		line.Ping = stats.Ping.Latency
+		line.Jitter = stats.Ping.Jitter
+	}
+	if stats.Server != nil {
+		line.Server = stats.Server.Name
+		line.Location = stats.Server.Location
+	}
+	var b strings.Builder
+	if err := s.Template.Execute(&b, line); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(strings.TrimSpace(b.String()), "\n", " "), nil
+}
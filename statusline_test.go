@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStatusLineRender(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{name: "default", format: defaultStatusLineFormat, want: "↓100.0 ↑20.0 ping 10ms loss 0% @UNC Chapel Hill"},
+		{name: "custom", format: `{{.ISP}} {{.Download}}/{{.Upload}} Mbps, jitter {{.Jitter}}ms from {{.Location}}`, want: "Acme 100/20 Mbps, jitter 1.2ms from Chapel Hill, NC"},
+		{name: "multiline", format: "{{.Server}}\n{{.Method}}\n", want: "UNC Chapel Hill ookla"},
+	}
+	stats := loadResult(t, "result.json")
+	stats.Method = MethodOokla
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := NewStatusLineSink("-", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := sink.(*StatusLineSink).Render(stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStatusLineSinkInvalid(t *testing.T) {
+	if _, err := NewStatusLineSink("-", "{{.Download"); err == nil {
+		t.Error("got no error for an invalid template")
+	}
+}
+
+func TestStatusLineSinkPublish(t *testing.T) {
+	stats := loadResult(t, "result.json")
+	const want = "↓100.0 ↑20.0 ping 10ms loss 0% @UNC Chapel Hill"
+
+	path := filepath.Join(t.TempDir(), "status")
+	sink, err := NewStatusLineSink(path, defaultStatusLineFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each run replaces the previous line.
+	for range 2 {
+		if err := sink.Publish(stats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != want+"\n" {
+		t.Errorf("got file content %q, want %q", got, want+"\n")
+	}
+
+	var stdout bytes.Buffer
+	sink = &StatusLineSink{Path: "-", Template: sink.(*StatusLineSink).Template, stdout: &stdout}
+	if err := sink.Publish(stats); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "\r\033[K"+want {
+		t.Errorf("got stdout %q, want %q", got, "\r\033[K"+want)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "fmt"
+
+// sessionStats aggregates the runs since the tool started, for the shutdown summary.
+type sessionStats struct {
+	runs, failures     int
+	download, upload   float64 // Sum of the rates in Mbps
+	downloads, uploads int
+}
+
+// accumulate adds the published results to the session aggregates.
+func (t *SpeedTester) accumulate(stats *Stats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if stats.Download != nil {
+		t.session.download += stats.Download.GetBandWithInMbps()
+		t.session.downloads++
+	}
+	if stats.Upload != nil {
+		t.session.upload += stats.Upload.GetBandWithInMbps()
+		t.session.uploads++
+	}
+}
+
+// Summary returns a single-line description of the runs since the tool started.
+func (t *SpeedTester) Summary() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.session
+	summary := fmt.Sprintf("Session summary: %d runs, %d successes, %d failures", s.runs, s.runs-s.failures, s.failures)
+	if s.downloads > 0 {
+		summary += fmt.Sprintf(", average download %.2f Mbps", s.download/float64(s.downloads))
+	}
+	if s.uploads > 0 {
+		summary += fmt.Sprintf(", average upload %.2f Mbps", s.upload/float64(s.uploads))
+	}
+	return summary
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestSessionSummary(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures []string // Results of each run, empty for a failed run
+		want     string
+	}{
+		{name: "no runs", want: "Session summary: 0 runs, 0 successes, 0 failures"},
+		{name: "only failures", fixtures: []string{"", ""}, want: "Session summary: 2 runs, 0 successes, 2 failures"},
+		{
+			name:     "mixed",
+			fixtures: []string{"result.json", "", "result_25gbps.json"},
+			want:     "Session summary: 3 runs, 2 successes, 1 failures, average download 12550.00 Mbps, average upload 10010.00 Mbps",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := &SpeedTester{}
+			tester.Metrics()
+			failing := fakeCLI(t, "exit 1")
+			for _, fixture := range tt.fixtures {
+				tester.Command = failing
+				if fixture != "" {
+					tester.Command = fixtureCLI(t, fixture)
+				}
+				tester.Run()
+			}
+			if got := tester.Summary(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
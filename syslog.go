@@ -0,0 +1,71 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":   syslog.LOG_KERN,
+	"user":   syslog.LOG_USER,
+	"daemon": syslog.LOG_DAEMON,
+	"local0": syslog.LOG_LOCAL0,
+	"local1": syslog.LOG_LOCAL1,
+	"local2": syslog.LOG_LOCAL2,
+	"local3": syslog.LOG_LOCAL3,
+	"local4": syslog.LOG_LOCAL4,
+	"local5": syslog.LOG_LOCAL5,
+	"local6": syslog.LOG_LOCAL6,
+	"local7": syslog.LOG_LOCAL7,
+}
+
+// SyslogSink sends the results of each run to a local or remote syslog server.
+type SyslogSink struct {
+	Format string // summary, json or both
+	writer *syslog.Writer
+}
+
+// NewSyslogSink connects to the syslog server; an empty network connects to the local daemon.
+// It fails with errSyslogUnavailable when the server cannot be reached.
+func NewSyslogSink(network, address, facility, format string) (Sink, error) {
+	switch format {
+	case "summary", "json", "both":
+	default:
+		return nil, fmt.Errorf("invalid format %q", format)
+	}
+	priority, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("invalid facility %q", facility)
+	}
+	writer, err := syslog.Dial(network, address, priority|syslog.LOG_INFO, "speedtester")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errSyslogUnavailable, err)
+	}
+	return &SyslogSink{Format: format, writer: writer}, nil
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) Publish(stats *Stats) error {
+	if s.Format != "json" {
+		if err := s.writer.Info(stats.Summary()); err != nil {
+			return err
+		}
+	}
+	if s.Format != "summary" {
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		if err := s.writer.Info(string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
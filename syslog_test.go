@@ -0,0 +1,148 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syslogServer listens for syslog messages over UDP, returning its address and the
+// received messages.
+func syslogServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), messages
+}
+
+func TestNewSyslogSink(t *testing.T) {
+	address, _ := syslogServer(t)
+	missing := filepath.Join(t.TempDir(), "log")
+	tests := []struct {
+		name             string
+		network, address string
+		facility, format string
+		wantErr          bool
+		wantUnavailable  bool
+	}{
+		{name: "valid", network: "udp", address: address, facility: "local0", format: "summary"},
+		{name: "facility is case-insensitive", network: "udp", address: address, facility: "DAEMON", format: "json"},
+		{name: "invalid format", network: "udp", address: address, facility: "daemon", format: "xml", wantErr: true},
+		{name: "invalid facility", network: "udp", address: address, facility: "local8", format: "both", wantErr: true},
+		{name: "invalid facility, unreachable", network: "unixgram", address: missing, facility: "local8", format: "both", wantErr: true},
+		{name: "unreachable", network: "unixgram", address: missing, facility: "daemon", format: "summary", wantErr: true, wantUnavailable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSyslogSink(tt.network, tt.address, tt.facility, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+			if got := errors.Is(err, errSyslogUnavailable); got != tt.wantUnavailable {
+				t.Errorf("got error %v, want unavailable: %t", err, tt.wantUnavailable)
+			}
+		})
+	}
+}
+
+func TestSyslogSinkPublish(t *testing.T) {
+	stats := loadResult(t, "result.json")
+	tests := []struct {
+		format string
+		want   []string // Expected content of each message
+	}{
+		{"summary", []string{stats.Summary()}},
+		{"json", []string{`"isp":"Acme"`}},
+		{"both", []string{stats.Summary(), `"isp":"Acme"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			address, messages := syslogServer(t)
+			sink, err := NewSyslogSink("udp", address, "local0", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Publish(stats); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				select {
+				case msg := <-messages:
+					// local0.info is priority 134.
+					if !strings.HasPrefix(msg, "<134>") || !strings.Contains(msg, "speedtester") || !strings.Contains(msg, want) {
+						t.Errorf("got message %q, want one containing %q", msg, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("timed out waiting for a message containing %q", want)
+				}
+			}
+			select {
+			case msg := <-messages:
+				t.Errorf("unexpected message %q", msg)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestBuildSyslog(t *testing.T) {
+	address, _ := syslogServer(t)
+	missing := filepath.Join(t.TempDir(), "log")
+	tests := []struct {
+		name     string
+		args     []string
+		wantErr  string
+		wantSink bool
+	}{
+		{name: "enabled", args: []string{"-syslog", "-syslog-network=udp", "-syslog-address=" + address}, wantSink: true},
+		{name: "invalid facility", args: []string{"-syslog", "-syslog-facility=cron2"}, wantErr: `invalid facility "cron2"`},
+		{name: "invalid format", args: []string{"-syslog", "-syslog-format=xml"}, wantErr: `invalid format "xml"`},
+		{name: "unreachable", args: []string{"-syslog", "-syslog-network=unixgram", "-syslog-address=" + missing}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("run", flag.ContinueOnError)
+			options := newTesterOptions(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			runner, err := options.build()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasSink := false
+			for _, sink := range runner.Sinks {
+				hasSink = hasSink || sink.Name() == "syslog"
+			}
+			if hasSink != tt.wantSink {
+				t.Errorf("got the syslog sink: %t, want %t", hasSink, tt.wantSink)
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package main
+
+import "fmt"
+
+// NewSyslogSink always fails with errSyslogUnavailable on this platform.
+func NewSyslogSink(network, address, facility, format string) (Sink, error) {
+	return nil, fmt.Errorf("%w: not supported on this platform", errSyslogUnavailable)
+}
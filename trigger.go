@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Limits for the annotations of on-demand runs.
+const (
+	maxAnnotationsSize  = 4096
+	maxAnnotations      = 16
+	maxAnnotationLength = 256
+)
+
+var annotationKeyRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+// ParseAnnotations decodes and validates the annotations of an on-demand run from a JSON object
+// with string values. An empty body means no annotations.
+func ParseAnnotations(r io.Reader) (map[string]string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxAnnotationsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAnnotationsSize {
+		return nil, fmt.Errorf("annotations exceed %d bytes", maxAnnotationsSize)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return nil, nil
+	}
+	var annotations map[string]string
+	if err := json.Unmarshal(data, &annotations); err != nil {
+		return nil, fmt.Errorf("expected a JSON object with string values: %v", err)
+	}
+	if len(annotations) > maxAnnotations {
+		return nil, fmt.Errorf("too many annotations, the maximum is %d", maxAnnotations)
+	}
+	for key, value := range annotations {
+		if !annotationKeyRegex.MatchString(key) {
+			return nil, fmt.Errorf("invalid annotation key %q", key)
+		}
+		if len(value) > maxAnnotationLength {
+			return nil, fmt.Errorf("annotation %q exceeds %d characters", key, maxAnnotationLength)
+		}
+	}
+	return annotations, nil
+}
+
+// formatAnnotations returns the annotations as sorted key=value pairs for logging.
+func formatAnnotations(annotations map[string]string) string {
+	var pairs []string
+	for key, value := range annotations {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, value))
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// DeduplicatedResponse is the body of a /run skipped within the dedup window, carrying the
+// previously published results, so they cannot be mistaken for fresh ones.
+type DeduplicatedResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Results *Stats `json:"results"`
+}
+
+// RunHandler returns an HTTP handler that triggers a run on demand via POST, with optional
+// annotations as a JSON object on the body, and responds with the results.
+func (t *SpeedTester) RunHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		annotations, err := ParseAnnotations(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		stats, err := t.TryRun(annotations)
+		if errors.Is(err, ErrBusy) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if errors.Is(err, ErrDeduplicated) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(DeduplicatedResponse{Status: "deduplicated", Message: err.Error(), Results: stats})
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
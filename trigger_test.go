@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "empty body", body: ""},
+		{name: "blank body", body: " \n"},
+		{name: "valid", body: `{"reason":"user-reported-slowness","ticket.id":"T-1"}`, want: map[string]string{"reason": "user-reported-slowness", "ticket.id": "T-1"}},
+		{name: "not an object", body: `["reason"]`, wantErr: "expected a JSON object"},
+		{name: "non-string value", body: `{"count":1}`, wantErr: "expected a JSON object"},
+		{name: "invalid key", body: `{"the reason":"x"}`, wantErr: "invalid annotation key"},
+		{name: "long value", body: `{"reason":"` + strings.Repeat("x", maxAnnotationLength+1) + `"}`, wantErr: "exceeds 256 characters"},
+		{name: "too many", body: manyAnnotations(maxAnnotations + 1), wantErr: "too many annotations"},
+		{name: "too large", body: `{"reason":"` + strings.Repeat("x", maxAnnotationsSize) + `"}`, wantErr: "exceed 4096 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAnnotations(strings.NewReader(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for key, value := range tt.want {
+				if got[key] != value {
+					t.Errorf("annotation %s = %q, want %q", key, got[key], value)
+				}
+			}
+		})
+	}
+}
+
+func manyAnnotations(n int) string {
+	annotations := make(map[string]string)
+	for i := range n {
+		annotations[strings.Repeat("k", i+1)] = "v"
+	}
+	data, _ := json.Marshal(annotations)
+	return string(data)
+}
+
+func TestRunHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		setup      func(*SpeedTester) // Prepares the tester before the request
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "method not allowed", method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{name: "invalid annotations", method: http.MethodPost, body: `{"the reason":"x"}`, wantStatus: http.StatusBadRequest},
+		{name: "fresh results", method: http.MethodPost, body: `{"reason":"manual"}`, wantStatus: http.StatusOK, wantBody: `"annotations":{"reason":"manual"}`},
+		{
+			name:   "busy",
+			method: http.MethodPost,
+			setup: func(tester *SpeedTester) {
+				tester.running.Lock()
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   ErrBusy.Error(),
+		},
+		{
+			name:   "deduplicated",
+			method: http.MethodPost,
+			setup: func(tester *SpeedTester) {
+				tester.DedupWindow = 30 * time.Second
+				if err := tester.Run(); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   `"status":"deduplicated"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tester := newLoopTester(newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+			if tt.setup != nil {
+				tt.setup(tester)
+			}
+			req := httptest.NewRequest(tt.method, "/run", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			tester.RunHandler().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("got body %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRunHandlerDeduplicatedResults(t *testing.T) {
+	tester := newLoopTester(newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)))
+	tester.DedupWindow = 30 * time.Second
+	if err := tester.Run(); err != nil {
+		t.Fatal(err)
+	}
+	previous := tester.Latest()
+
+	rec := httptest.NewRecorder()
+	tester.RunHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/run", nil))
+	var response DeduplicatedResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Status != "deduplicated" || response.Message == "" {
+		t.Errorf("got status %q and message %q", response.Status, response.Message)
+	}
+	if response.Results == nil || response.Results.RunID != previous.RunID {
+		t.Errorf("got results %+v, want those of run %s", response.Results, previous.RunID)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultUpdateURL    = "https://api.github.com/repos/agalue/speedtester/releases/latest"
+	updateCheckInterval = 24 * time.Hour
+)
+
+// UpdateChecker queries the latest release of the exporter, to flag the hosts running
+// old versions. It never updates the exporter.
+type UpdateChecker struct {
+	URL    string // Returns the latest release as JSON with a tag_name, like the GitHub API
+	Client *http.Client
+}
+
+// NewUpdateChecker creates a checker querying the given http or https URL. The
+// requests honor the standard proxy environment variables.
+func NewUpdateChecker(rawURL string) (*UpdateChecker, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("expected an http or https URL")
+	}
+	return &UpdateChecker{URL: u.String(), Client: newHTTPClient(30 * time.Second)}, nil
+}
+
+// Latest returns the version of the latest release.
+func (c *UpdateChecker) Latest(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("the release has no tag_name")
+	}
+	return release.TagName, nil
+}
+
+// checkUpdates checks for a newer release on start and daily, until the context is done.
+func (t *SpeedTester) checkUpdates(ctx context.Context, checker *UpdateChecker) {
+	current := currentVersion()
+	for {
+		latest, err := checker.Latest(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("cannot check for updates: %v", err)
+		} else {
+			newer, known := newerVersion(latest, current)
+			available := 0.0
+			if !known {
+				log.Printf("cannot compare the version %s with the latest release %s", current, latest)
+			} else if newer {
+				log.Printf("A newer version of the exporter is available: %s (running %s)", latest, current)
+				available = 1
+			}
+			t.promStats.UpdateAvailable.Reset()
+			t.promStats.UpdateAvailable.WithLabelValues(latest).Set(available)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.clock().After(updateCheckInterval):
+		}
+	}
+}
+
+// newerVersion returns whether latest is a newer version than current, as
+// [v]major.minor.patch, ignoring the pre-release and build suffixes. It is unknown
+// when either cannot be parsed, e.g. for development builds.
+func newerVersion(latest, current string) (newer, known bool) {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false, false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false, false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i], true
+		}
+	}
+	return false, true
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > len(parsed) {
+		return parsed, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		newer, known    bool
+	}{
+		{"v1.3.0", "1.2.0", true, true},
+		{"v1.2.0", "v1.2.0", false, true},
+		{"v1.2.0", "v1.10.0", false, true},
+		{"v2.0", "v1.9.9", true, true},
+		{"v1.2.1-rc.1", "v1.2.0+dirty", true, true},
+		{"v1.2.0", "dev", false, false},
+		{"latest", "v1.2.0", false, false},
+		{"v1.2.3.4", "v1.2.3", false, false},
+	}
+	for _, tt := range tests {
+		if newer, known := newerVersion(tt.latest, tt.current); newer != tt.newer || known != tt.known {
+			t.Errorf("newerVersion(%q, %q) = %t, %t, want %t, %t", tt.latest, tt.current, newer, known, tt.newer, tt.known)
+		}
+	}
+}
+
+// releaseEndpoint is a fake release endpoint, like the one of the GitHub API.
+type releaseEndpoint struct {
+	mu     sync.Mutex
+	status int
+	body   string
+	header http.Header // Of the last request
+}
+
+func newReleaseEndpoint(t *testing.T, status int, body string) (*releaseEndpoint, string) {
+	t.Helper()
+	endpoint := &releaseEndpoint{status: status, body: body}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.mu.Lock()
+		defer endpoint.mu.Unlock()
+		endpoint.header = r.Header.Clone()
+		w.WriteHeader(endpoint.status)
+		w.Write([]byte(endpoint.body))
+	}))
+	t.Cleanup(server.Close)
+	return endpoint, server.URL
+}
+
+func (e *releaseEndpoint) Set(status int, body string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.status, e.body = status, body
+}
+
+func TestNewUpdateChecker(t *testing.T) {
+	for _, tt := range []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: defaultUpdateURL},
+		{url: "http://releases.example.com/latest.json"},
+		{url: "ftp://releases.example.com/latest", wantErr: true},
+		{url: "/latest", wantErr: true},
+	} {
+		if _, err := NewUpdateChecker(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("NewUpdateChecker(%q) got error %v, want an error: %t", tt.url, err, tt.wantErr)
+		}
+	}
+}
+
+func TestUpdateCheckerLatest(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "release", status: http.StatusOK, body: `{"tag_name":"v1.3.0","name":"Release 1.3.0"}`, want: "v1.3.0"},
+		{name: "not found", status: http.StatusNotFound, body: `{"message":"Not Found"}`, wantErr: "unexpected status 404"},
+		{name: "rate limited", status: http.StatusForbidden, wantErr: "unexpected status 403"},
+		{name: "no tag", status: http.StatusOK, body: `{"name":"Release"}`, wantErr: "no tag_name"},
+		{name: "invalid JSON", status: http.StatusOK, body: `<html>`, wantErr: "invalid character"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, url := newReleaseEndpoint(t, tt.status, tt.body)
+			checker, err := NewUpdateChecker(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := checker.Latest(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := endpoint.header.Get("Accept"); got != "application/vnd.github+json" {
+				t.Errorf("Accept = %q, want the GitHub media type", got)
+			}
+		})
+	}
+}
+
+func TestCheckUpdates(t *testing.T) {
+	previous := version
+	version = "v1.2.0"
+	t.Cleanup(func() { version = previous })
+	endpoint, url := newReleaseEndpoint(t, http.StatusOK, `{"tag_name":"v1.3.0"}`)
+	checker, err := NewUpdateChecker(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	tester := newLoopTester(clock)
+	metrics := tester.Metrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tester.checkUpdates(ctx, checker)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Checked on start, and then daily.
+	steps := []struct {
+		body string
+		want string
+	}{
+		{`{"tag_name":"v1.3.0"}`, `speedtest_exporter_update_available{latest_version="v1.3.0"} 1`},
+		{`{"tag_name":"v1.2.0"}`, `speedtest_exporter_update_available{latest_version="v1.2.0"} 0`},
+	}
+	for i, step := range steps {
+		if i > 0 {
+			endpoint.Set(http.StatusOK, step.body)
+			clock.Advance(updateCheckInterval)
+		}
+		clock.WaitForTimers(t, 1)
+		want := `
+# HELP speedtest_exporter_update_available Whether a newer release of the exporter is available (1) or not (0), with the latest version as a label
+# TYPE speedtest_exporter_update_available gauge
+` + step.want + "\n"
+		if err := testutil.CollectAndCompare(metrics.UpdateAvailable, strings.NewReader(want)); err != nil {
+			t.Errorf("check %d: %v", i+1, err)
+		}
+	}
+}
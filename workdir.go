@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// CheckWorkDir verifies that the given path is a writable directory, so the CLI can
+// use it for its scratch data (e.g. on containers with a read-only root filesystem).
+func CheckWorkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".speedtester-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// workDirEnv returns the environment variables for the CLI to use the work directory for its temporary files.
+func workDirEnv(dir string) []string {
+	return []string{"TMPDIR=" + dir}
+}
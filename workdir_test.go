@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	readOnly := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr string
+	}{
+		{name: "writable", dir: dir},
+		{name: "missing", dir: filepath.Join(dir, "missing"), wantErr: "no such file or directory"},
+		{name: "not a directory", dir: file, wantErr: "is not a directory"},
+		{name: "read-only", dir: readOnly, wantErr: "is not writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.dir == readOnly && os.Geteuid() == 0 {
+				t.Skip("root can write on read-only directories")
+			}
+			err := CheckWorkDir(tt.dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries, _ := os.ReadDir(tt.dir)
+				for _, entry := range entries {
+					if strings.HasPrefix(entry.Name(), ".speedtester-") {
+						t.Errorf("left the probe file %s behind", entry.Name())
+					}
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecuteWorkDir(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "result.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", "/var/tmp")
+	tests := []struct {
+		name       string
+		workDir    bool
+		wantTmpDir string // Expected TMPDIR, the work dir when empty
+	}{
+		{name: "default", wantTmpDir: "/var/tmp"},
+		{name: "work dir", workDir: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := filepath.Join(t.TempDir(), "trace")
+			cli := fakeCLI(t, `
+echo "$PWD" > '`+trace+`'
+echo "$TMPDIR" >> '`+trace+`'
+cat '`+fixture+`'`)
+			tester := &SpeedTester{Command: cli}
+			wantDir, wantTmpDir := cwd, tt.wantTmpDir
+			if tt.workDir {
+				tester.WorkDir = t.TempDir()
+				wantDir, wantTmpDir = tester.WorkDir, tester.WorkDir
+			}
+			tester.Metrics()
+			if _, err := tester.execute(testLogger(t), 0, ""); err != nil {
+				t.Fatal(err)
+			}
+			data, err := os.ReadFile(trace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(got) != 2 || got[0] != wantDir || got[1] != wantTmpDir {
+				t.Errorf("the CLI ran in %q with TMPDIR=%q, want %q and %q", got[0], got[len(got)-1], wantDir, wantTmpDir)
+			}
+		})
+	}
+}
+
+func TestBuildWorkDir(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "writable", args: []string{"-work-dir", dir}},
+		{name: "missing", args: []string{"-work-dir", filepath.Join(dir, "missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("run", flag.ContinueOnError)
+			options := newTesterOptions(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := options.build(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want an error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}